@@ -1,19 +1,30 @@
-package base58
-
-import (
-	"github.com/dev-rodrigobaliza/go-blockchain/utils"
-	"github.com/mr-tron/base58"
-)
-
-func Encode(input []byte) []byte {
-	encode := base58.Encode(input)
-
-	return []byte(encode)
-}
-
-func Decode(input []byte) []byte {
-	decode, err := base58.Decode(string(input[:]))
-	utils.Handle(err)
-
-	return decode
-}
\ No newline at end of file
+package base58
+
+import (
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	"github.com/mr-tron/base58"
+)
+
+func Encode(input []byte) []byte {
+	encode := base58.Encode(input)
+
+	return []byte(encode)
+}
+
+func Decode(input []byte) []byte {
+	decode, err := base58.Decode(string(input[:]))
+	utils.Handle(err)
+
+	return decode
+}
+
+// TryDecode decodes input like Decode, but reports input that is not base58
+// instead of panicking, for input coming from outside the node.
+func TryDecode(input []byte) ([]byte, bool) {
+	decode, err := base58.Decode(string(input[:]))
+	if err != nil {
+		return nil, false
+	}
+
+	return decode, true
+}
@@ -1,232 +1,535 @@
-package blockchain
-
-import (
-	"bytes"
-	"encoding/hex"
-
-	"github.com/dev-rodrigobaliza/go-blockchain/utils"
-	"github.com/dgraph-io/badger"
-)
-
-var (
-	utxoPrefix = []byte("utxo-")
-)
-
-type UTXOSet struct {
-	Blockchain *BlockChain
-}
-
-func (u *UTXOSet) Reindex() {
-	db := u.Blockchain.Database
-
-	u.DeleteByPrefix(utxoPrefix)
-
-	UTXO := u.Blockchain.FindUTXO()
-
-	err := db.Update(func(txn *badger.Txn) error {
-		for txId, outs := range UTXO {
-			key, err := hex.DecodeString(txId)
-			if err != nil {
-				return err
-			}
-			key = append(utxoPrefix, key...)
-
-			err = txn.Set(key, outs.serialize())
-			utils.Handle(err)
-		}
-
-		return nil
-	})
-	utils.Handle(err)
-}
-
-func (u *UTXOSet) Update(block Block) {
-	db := u.Blockchain.Database
-
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					utils.Handle(err)
-					var outs TxOutputs
-					err = item.Value(func(val []byte) error {
-						return outs.deserialize(val)
-					})
-					utils.Handle(err)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-
-					if len(updatedOuts.Outputs) == 0 {
-						err := txn.Delete(inID)
-						if err != nil {
-							utils.Handle(err)
-						}
-					}
-
-					err = txn.Set(inID, updatedOuts.serialize())
-					utils.Handle(err)
-				}
-			}
-
-			newOutputs := TxOutputs{}
-			newOutputs.Outputs = append(newOutputs.Outputs, tx.Outputs...)
-
-			txID := append(utxoPrefix, tx.ID...)
-			err := txn.Set(txID, newOutputs.serialize())
-			utils.Handle(err)
-		}
-
-		return nil
-	})
-	utils.Handle(err)
-}
-
-func (u *UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
-	var UTXOs []TxOutput
-
-	db := u.Blockchain.Database
-
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			var outs TxOutputs
-			item := it.Item()
-			err := item.Value(func(val []byte) error {
-				return outs.deserialize(val)
-			})
-			utils.Handle(err)
-
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
-			}
-		}
-
-		return nil
-	})
-	utils.Handle(err)
-
-	return UTXOs
-}
-
-func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspentOuts := make(map[string][]int)
-	accumulated := 0
-
-	db := u.Blockchain.Database
-
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			var outs TxOutputs
-			item := it.Item()
-			err := item.Value(func(val []byte) error {
-				return outs.deserialize(val)
-			})
-			utils.Handle(err)
-			id := item.Key()
-			id = bytes.TrimPrefix(id, utxoPrefix)
-			txID := hex.EncodeToString(id)
-
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
-				}
-			}
-
-		}
-
-		return nil
-	})
-	utils.Handle(err)
-
-	return accumulated, unspentOuts
-}
-
-func (u *UTXOSet) CountTransactions() int {
-	db := u.Blockchain.Database
-	counter := 0
-
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			counter++
-		}
-
-		return nil
-	})
-	utils.Handle(err)
-
-	return counter
-}
-
-func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
-	deleteKeys := func(keysForDelete [][]byte) error {
-		err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
-			for _, key := range keysForDelete {
-				err := txn.Delete(key)
-				if err != nil {
-					return err
-				}
-			}
-
-			return nil
-		})
-
-		return err
-	}
-
-	collectSize := 100000
-	u.Blockchain.Database.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		keysForDelete := make([][]byte, 0, collectSize)
-		keysCollected := 0
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := it.Item().KeyCopy(nil)
-			keysForDelete = append(keysForDelete, key)
-			keysCollected++
-			if keysCollected == collectSize {
-				err := deleteKeys(keysForDelete)
-				if err != nil {
-					utils.Handle(err)
-				}
-				keysForDelete = make([][]byte, 0, collectSize)
-				keysCollected = 0
-			}
-		}
-
-		if keysCollected > 0 {
-			err := deleteKeys(keysForDelete)
-			if err != nil {
-				utils.Handle(err)
-			}
-		}
-
-		return nil
-	})
-}
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	"github.com/dgraph-io/badger"
+	"github.com/goccy/go-json"
+)
+
+var (
+	// utxoPrefix starts the key of every unspent output, followed by the ID
+	// of its transaction and its index as a big endian uint32.
+	utxoPrefix = []byte("utxo:")
+
+	// legacyUtxoPrefix starts the keys of the previous layout, one blob of
+	// outputs per transaction.
+	legacyUtxoPrefix = []byte("utxo-")
+
+	// undoPrefix starts the key of the undo record of a block, followed by
+	// its hash. The record holds the outputs spent by the block.
+	undoPrefix = []byte("undo-")
+)
+
+// ErrNoUndoData is returned when disconnecting a block without undo record.
+var ErrNoUndoData = errors.New("block has no undo data")
+
+// outIndexLength is the size of the output index at the end of a key.
+const outIndexLength = 4
+
+type UTXOSet struct {
+	Blockchain *BlockChain
+}
+
+// utxoKey returns the key of the output at index outIdx of txID.
+func utxoKey(txID []byte, outIdx int) []byte {
+	key := make([]byte, 0, len(utxoPrefix)+len(txID)+outIndexLength)
+	key = append(key, utxoPrefix...)
+	key = append(key, txID...)
+
+	return binary.BigEndian.AppendUint32(key, uint32(outIdx))
+}
+
+// parseUtxoKey returns the transaction ID and the output index of a key.
+func parseUtxoKey(key []byte) ([]byte, int) {
+	key = bytes.TrimPrefix(key, utxoPrefix)
+	split := len(key) - outIndexLength
+
+	return key[:split], int(binary.BigEndian.Uint32(key[split:]))
+}
+
+// Reindex rebuilds the UTXO set from the blocks of the active chain.
+func (u *UTXOSet) Reindex() {
+	db := u.Blockchain.Database
+
+	u.DeleteByPrefix(legacyUtxoPrefix)
+	u.DeleteByPrefix(utxoPrefix)
+
+	UTXO := u.Blockchain.FindUTXO()
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for key, entry := range UTXO {
+		err := wb.Set([]byte(key), entry.serialize())
+		utils.Handle(err)
+	}
+
+	err := wb.Flush()
+	utils.Handle(err)
+}
+
+// Migrate moves a UTXO set stored in the legacy layout, one blob per
+// transaction, to one key per output. The legacy blobs do not keep the
+// index of their outputs once some are spent, so the set is rebuilt from
+// the chain. It reports whether anything had to be migrated.
+func (u *UTXOSet) Migrate() bool {
+	legacy := false
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		it.Seek(legacyUtxoPrefix)
+		legacy = it.ValidForPrefix(legacyUtxoPrefix)
+
+		return nil
+	})
+	utils.Handle(err)
+
+	if !legacy {
+		return false
+	}
+
+	u.Reindex()
+
+	return true
+}
+
+// ConnectBlock applies the transactions of a block whose parent is the tip
+// of the active chain: the outputs they spend are removed and the ones they
+// create are added. The spent outputs are kept as the undo record of the
+// block, and the block becomes the tip and is indexed by height, and by
+// transaction and address when enabled, all in one database transaction.
+func (u *UTXOSet) ConnectBlock(block *Block) error {
+	err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		spent, err := connectTransactions(txn, block)
+		if err != nil {
+			return err
+		}
+
+		buffer, err := json.Marshal(spent)
+		if err != nil {
+			return err
+		}
+
+		err = txn.Set(undoKey(block.Hash), buffer)
+		if err != nil {
+			return err
+		}
+
+		err = txn.Set(heightIndexKey(block.Height), block.Hash)
+		if err != nil {
+			return err
+		}
+
+		if u.Blockchain.txIndex {
+			err = indexTransactions(txn, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		if u.Blockchain.addrIndex {
+			err = indexAddresses(txn, block, spent)
+			if err != nil {
+				return err
+			}
+		}
+
+		return txn.Set([]byte(lastHashPrefix), block.Hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	u.Blockchain.setTip(block.Hash)
+
+	return nil
+}
+
+// DisconnectBlock reverts ConnectBlock for the tip of the active chain: the
+// outputs created by the block are removed, the ones it spent are restored
+// from its undo record and its parent becomes the tip, dropping the block
+// from the height, transaction and address indexes, all in one database
+// transaction. ErrNoUndoData is returned for blocks connected before undo
+// records were kept.
+func (u *UTXOSet) DisconnectBlock(block *Block) error {
+	if !bytes.Equal(block.Hash, u.Blockchain.Tip()) {
+		return fmt.Errorf("block %x is not the tip of the active chain", block.Hash)
+	}
+
+	err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(undoKey(block.Hash))
+		if err == badger.ErrKeyNotFound {
+			return ErrNoUndoData
+		}
+		if err != nil {
+			return err
+		}
+
+		var spent []UtxoEntry
+		err = item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &spent)
+		})
+		if err != nil {
+			return err
+		}
+
+		err = disconnectTransactions(txn, block, spent)
+		if err != nil {
+			return err
+		}
+
+		err = txn.Delete(undoKey(block.Hash))
+		if err != nil {
+			return err
+		}
+
+		err = txn.Delete(heightIndexKey(block.Height))
+		if err != nil {
+			return err
+		}
+
+		if u.Blockchain.txIndex {
+			err = unindexTransactions(txn, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		if u.Blockchain.addrIndex {
+			err = unindexAddresses(txn, block, spent)
+			if err != nil {
+				return err
+			}
+		}
+
+		return txn.Set([]byte(lastHashPrefix), block.PrevHash)
+	})
+	if err != nil {
+		return err
+	}
+
+	u.Blockchain.setTip(block.PrevHash)
+
+	return nil
+}
+
+func undoKey(blockHash []byte) []byte {
+	return append(append([]byte{}, undoPrefix...), blockHash...)
+}
+
+func connectTransactions(txn *badger.Txn, block *Block) ([]UtxoEntry, error) {
+	var spent []UtxoEntry
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				key := utxoKey(in.ID, in.Out)
+
+				entry, err := getUtxoEntry(txn, key)
+				if err != nil {
+					return nil, fmt.Errorf("transaction %x spends %s: %w", tx.ID, outpointKey(in.ID, in.Out), err)
+				}
+				spent = append(spent, entry)
+
+				err = txn.Delete(key)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		for outIdx, out := range tx.Outputs {
+			entry := UtxoEntry{
+				Output:   out,
+				Coinbase: tx.IsCoinbase(),
+				Height:   block.Height,
+			}
+
+			err := txn.Set(utxoKey(tx.ID, outIdx), entry.serialize())
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return spent, nil
+}
+
+func disconnectTransactions(txn *badger.Txn, block *Block, spent []UtxoEntry) error {
+	next := len(spent)
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+
+		for outIdx := range tx.Outputs {
+			err := txn.Delete(utxoKey(tx.ID, outIdx))
+			if err != nil {
+				return err
+			}
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for j := len(tx.Inputs) - 1; j >= 0; j-- {
+			if next == 0 {
+				return errors.New("not enough spent outputs to undo the block")
+			}
+			next--
+
+			in := tx.Inputs[j]
+			err := txn.Set(utxoKey(in.ID, in.Out), spent[next].serialize())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if next != 0 {
+		return errors.New("more spent outputs than inputs in the block")
+	}
+
+	return nil
+}
+
+func getUtxoEntry(txn *badger.Txn, key []byte) (UtxoEntry, error) {
+	var entry UtxoEntry
+
+	item, err := txn.Get(key)
+	if err != nil {
+		return entry, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		return entry.deserialize(val)
+	})
+
+	return entry, err
+}
+
+// FindOutput returns the output at index outIdx of the given transaction if
+// it is still unspent.
+func (u *UTXOSet) FindOutput(txID []byte, outIdx int) (TxOutput, bool) {
+	entry, ok := u.FetchEntry(txID, outIdx)
+
+	return entry.Output, ok
+}
+
+// FetchEntry returns the output at index outIdx of the given transaction,
+// along with where it was created, if it is still unspent.
+func (u *UTXOSet) FetchEntry(txID []byte, outIdx int) (UtxoEntry, bool) {
+	if outIdx < 0 {
+		return UtxoEntry{}, false
+	}
+
+	var entry UtxoEntry
+
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		var err error
+		entry, err = getUtxoEntry(txn, utxoKey(txID, outIdx))
+
+		return err
+	})
+	if err != nil {
+		return UtxoEntry{}, false
+	}
+
+	return entry, true
+}
+
+// forEach calls fn with every entry of the UTXO set, in key order, so the
+// outputs of a transaction come one after the other.
+func (u *UTXOSet) forEach(fn func(txID []byte, outIdx int, entry *UtxoEntry)) {
+	err := u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
+			item := it.Item()
+
+			var entry UtxoEntry
+			err := item.Value(func(val []byte) error {
+				return entry.deserialize(val)
+			})
+			if err != nil {
+				return err
+			}
+
+			txID, outIdx := parseUtxoKey(item.Key())
+			fn(txID, outIdx, &entry)
+		}
+
+		return nil
+	})
+	utils.Handle(err)
+}
+
+func (u *UTXOSet) FindUnspentTransactions(pubKeyHash []byte) []TxOutput {
+	var UTXOs []TxOutput
+
+	u.forEach(func(_ []byte, _ int, entry *UtxoEntry) {
+		if entry.Output.IsLockedWithKey(pubKeyHash) {
+			UTXOs = append(UTXOs, entry.Output)
+		}
+	})
+
+	return UTXOs
+}
+
+// FindSpendableOutputs collects outputs locked with pubKeyHash until they
+// hold at least amount. Coinbase outputs that cannot be spent in the next
+// block yet are left out.
+func (u *UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+	unspentOuts := make(map[string][]int)
+	accumulated := 0
+	height := u.Blockchain.GetBestHeight() + 1
+	maturity := u.Blockchain.Params.CoinbaseMaturity
+
+	u.forEach(func(id []byte, outIdx int, entry *UtxoEntry) {
+		if accumulated >= amount || !entry.IsMature(height, maturity) {
+			return
+		}
+
+		if entry.Output.IsLockedWithKey(pubKeyHash) {
+			txID := hex.EncodeToString(id)
+			accumulated += entry.Output.Value
+			unspentOuts[txID] = append(unspentOuts[txID], outIdx)
+		}
+	})
+
+	return accumulated, unspentOuts
+}
+
+// GetBalance returns the value of the outputs locked with pubKeyHash that can
+// be spent in the next block, and the value of the coinbase outputs still
+// waiting to mature.
+func (u *UTXOSet) GetBalance(pubKeyHash []byte) (int, int) {
+	balance := 0
+	immature := 0
+	height := u.Blockchain.GetBestHeight() + 1
+	maturity := u.Blockchain.Params.CoinbaseMaturity
+
+	u.forEach(func(_ []byte, _ int, entry *UtxoEntry) {
+		if !entry.Output.IsLockedWithKey(pubKeyHash) {
+			return
+		}
+
+		if entry.IsMature(height, maturity) {
+			balance += entry.Output.Value
+		} else {
+			immature += entry.Output.Value
+		}
+	})
+
+	return balance, immature
+}
+
+// TxOutSetInfo summarizes the UTXO set.
+type TxOutSetInfo struct {
+	Height       int
+	BestBlock    []byte
+	Transactions int
+	Outputs      int
+	TotalAmount  int
+}
+
+// GetTxOutSetInfo walks the UTXO set and sums the value of every unspent
+// output, which is the supply in circulation at the tip.
+func (u *UTXOSet) GetTxOutSetInfo() TxOutSetInfo {
+	info := TxOutSetInfo{
+		Height:    u.Blockchain.GetBestHeight(),
+		BestBlock: u.Blockchain.Tip(),
+	}
+
+	var lastTxID []byte
+	u.forEach(func(txID []byte, _ int, entry *UtxoEntry) {
+		if !bytes.Equal(txID, lastTxID) {
+			info.Transactions++
+			lastTxID = append(lastTxID[:0], txID...)
+		}
+
+		info.Outputs++
+		info.TotalAmount += entry.Output.Value
+	})
+
+	return info
+}
+
+// CountTransactions returns the number of transactions with unspent outputs.
+func (u *UTXOSet) CountTransactions() int {
+	counter := 0
+
+	var lastTxID []byte
+	u.forEach(func(txID []byte, _ int, _ *UtxoEntry) {
+		if !bytes.Equal(txID, lastTxID) {
+			counter++
+			lastTxID = append(lastTxID[:0], txID...)
+		}
+	})
+
+	return counter
+}
+
+func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
+	deleteKeys := func(keysForDelete [][]byte) error {
+		err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
+			for _, key := range keysForDelete {
+				err := txn.Delete(key)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		return err
+	}
+
+	collectSize := 100000
+	u.Blockchain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		keysForDelete := make([][]byte, 0, collectSize)
+		keysCollected := 0
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().KeyCopy(nil)
+			keysForDelete = append(keysForDelete, key)
+			keysCollected++
+			if keysCollected == collectSize {
+				err := deleteKeys(keysForDelete)
+				if err != nil {
+					utils.Handle(err)
+				}
+				keysForDelete = make([][]byte, 0, collectSize)
+				keysCollected = 0
+			}
+		}
+
+		if keysCollected > 0 {
+			err := deleteKeys(keysForDelete)
+			if err != nil {
+				utils.Handle(err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,134 @@
+package blockchain
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+	"github.com/dev-rodrigobaliza/go-blockchain/wallet"
+)
+
+// utxoEntries returns the entries of the UTXO set keyed like FindUTXO.
+func utxoEntries(u *UTXOSet) map[string]UtxoEntry {
+	entries := make(map[string]UtxoEntry)
+	u.forEach(func(txID []byte, outIdx int, entry *UtxoEntry) {
+		entries[string(utxoKey(txID, outIdx))] = *entry
+	})
+
+	return entries
+}
+
+// spendCoinbase returns a transaction of owner sending the whole coinbase
+// output to another wallet.
+func spendCoinbase(chain *BlockChain, owner *wallet.Wallet, coinbase Transaction) Transaction {
+	to := string(wallet.NewWallet().Address(&params.RegTest))
+	tx := Transaction{
+		Inputs:  []TxInput{NewTxInput(coinbase.ID, 0, nil, owner.PublicKey)},
+		Outputs: []TxOutput{*NewTxOutput(coinbase.Outputs[0].Value, to)},
+	}
+	chain.SignTransaction(tx, *owner.GetPrivateKey())
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+func TestDisconnectBlock(t *testing.T) {
+	owner := wallet.NewWallet()
+	chain := newTestChain(t, owner)
+	UTXOSet := UTXOSet{chain}
+	address := string(owner.Address(&params.RegTest))
+
+	genesis, err := chain.GetBlock(chain.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent, err := chain.MineBlock([]Transaction{CoinbaseTx(address, "", CalcBlockSubsidy(1, &params.RegTest))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := utxoEntries(&UTXOSet)
+
+	tx := spendCoinbase(chain, owner, genesis.Transactions[0])
+	block, err := chain.MineBlock([]Transaction{CoinbaseTx(address, "", CalcBlockSubsidy(2, &params.RegTest)), tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = UTXOSet.DisconnectBlock(&block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(chain.Tip(), parent.Hash) {
+		t.Errorf("tip is %x, want the parent %x", chain.Tip(), parent.Hash)
+	}
+
+	// the spent coinbase comes back with its height and coinbase flag
+	if after := utxoEntries(&UTXOSet); !reflect.DeepEqual(after, before) {
+		t.Errorf("UTXO set after disconnecting is %v, want %v", after, before)
+	}
+
+	err = UTXOSet.DisconnectBlock(&block)
+	if err == nil {
+		t.Error("DisconnectBlock disconnected a block that is not the tip")
+	}
+}
+
+func TestOutputsSpentOneByOne(t *testing.T) {
+	owner := wallet.NewWallet()
+	chain := newTestChain(t, owner)
+	UTXOSet := UTXOSet{chain}
+	address := string(owner.Address(&params.RegTest))
+
+	genesis, err := chain.GetBlock(chain.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := genesis.Transactions[0]
+	value := coinbase.Outputs[0].Value
+
+	split := Transaction{
+		Inputs:  []TxInput{NewTxInput(coinbase.ID, 0, nil, owner.PublicKey)},
+		Outputs: []TxOutput{*NewTxOutput(value/2, address), *NewTxOutput(value-value/2, address)},
+	}
+	chain.SignTransaction(split, *owner.GetPrivateKey())
+	split.ID = split.Hash()
+
+	block, err := chain.MineBlock([]Transaction{CoinbaseTx(address, "", CalcBlockSubsidy(1, &params.RegTest)), split})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := UtxoEntry{Output: block.Transactions[0].Outputs[0], Coinbase: true, Height: 1}
+	if entry, ok := UTXOSet.FetchEntry(block.Transactions[0].ID, 0); !ok || !reflect.DeepEqual(entry, want) {
+		t.Errorf("coinbase entry is %v, want %v", entry, want)
+	}
+	for outIdx, out := range split.Outputs {
+		want := UtxoEntry{Output: out, Height: 1}
+		if entry, ok := UTXOSet.FetchEntry(split.ID, outIdx); !ok || !reflect.DeepEqual(entry, want) {
+			t.Errorf("entry of output %d is %v, want %v", outIdx, entry, want)
+		}
+	}
+
+	// spending the first output leaves the second one alone
+	tx := Transaction{
+		Inputs:  []TxInput{NewTxInput(split.ID, 0, nil, owner.PublicKey)},
+		Outputs: []TxOutput{*NewTxOutput(split.Outputs[0].Value, address)},
+	}
+	chain.SignTransaction(tx, *owner.GetPrivateKey())
+	tx.ID = tx.Hash()
+
+	_, err = chain.MineBlock([]Transaction{CoinbaseTx(address, "", CalcBlockSubsidy(2, &params.RegTest)), tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := UTXOSet.FetchEntry(split.ID, 0); ok {
+		t.Error("spent output is still in the UTXO set")
+	}
+	if _, ok := UTXOSet.FetchEntry(split.ID, 1); !ok {
+		t.Error("unspent output of a partly spent transaction is gone")
+	}
+}
@@ -0,0 +1,340 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	"github.com/dgraph-io/badger"
+	"github.com/goccy/go-json"
+)
+
+var (
+	// addrIndexPrefix starts the key of an event of the address index,
+	// followed by the pubkey hash of the address, the height of the block,
+	// the position of the transaction in the block, the kind of event and
+	// the index of the output or input, all numbers as big endian uint32.
+	// The events of an address are thus sorted in chain order.
+	addrIndexPrefix = []byte("addr-")
+
+	// addrIndexEnabledKey is set once the address index covers the whole
+	// active chain, from then on it is kept up to date.
+	addrIndexEnabledKey = []byte("addrindex")
+)
+
+// ErrNoAddrIndex is returned when querying the address index while it is not
+// enabled.
+var ErrNoAddrIndex = errors.New("address index is not enabled")
+
+// kinds of events, the inputs of a transaction come before its outputs
+const (
+	eventSpending byte = iota
+	eventFunding
+)
+
+// AddressEvent is an output of the active chain paying to an address, or an
+// input spending one of them.
+type AddressEvent struct {
+	Height    int    `json:"height"`
+	BlockHash []byte `json:"block_hash"`
+	TxID      []byte `json:"tx_id"`
+
+	// Index is the index of the output funding the address, or of the
+	// input spending from it.
+	Index int  `json:"index"`
+	Value int  `json:"value"`
+	Spend bool `json:"spend,omitempty"`
+}
+
+// AddressBalance is the balance of an address after a block touching it.
+type AddressBalance struct {
+	Height    int
+	BlockHash []byte
+	Balance   int
+}
+
+func addrIndexKey(pubKeyHash []byte, height, txPos int, kind byte, index int) []byte {
+	key := make([]byte, 0, len(addrIndexPrefix)+len(pubKeyHash)+13)
+	key = append(key, addrIndexPrefix...)
+	key = append(key, pubKeyHash...)
+	key = binary.BigEndian.AppendUint32(key, uint32(height))
+	key = binary.BigEndian.AppendUint32(key, uint32(txPos))
+	key = append(key, kind)
+
+	return binary.BigEndian.AppendUint32(key, uint32(index))
+}
+
+func addrIndexAddressPrefix(pubKeyHash []byte) []byte {
+	return append(append([]byte{}, addrIndexPrefix...), pubKeyHash...)
+}
+
+func (e *AddressEvent) serialize() []byte {
+	buffer, err := json.Marshal(e)
+	utils.Handle(err)
+
+	return buffer
+}
+
+func (e *AddressEvent) deserialize(buffer []byte) error {
+	return json.Unmarshal(buffer, e)
+}
+
+// addressEvents returns the events of a block keyed by their key in the
+// address index. The outputs spent by the block are given in the order of
+// its inputs.
+func addressEvents(block *Block, spent []UtxoEntry) (map[string]*AddressEvent, error) {
+	events := make(map[string]*AddressEvent)
+	next := 0
+
+	for txPos, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for inIdx := range tx.Inputs {
+				if next == len(spent) {
+					return nil, errors.New("not enough spent outputs to index the block")
+				}
+				out := spent[next].Output
+				next++
+
+				key := addrIndexKey(out.PubKeyHash, block.Height, txPos, eventSpending, inIdx)
+				events[string(key)] = &AddressEvent{
+					Height:    block.Height,
+					BlockHash: block.Hash,
+					TxID:      tx.ID,
+					Index:     inIdx,
+					Value:     out.Value,
+					Spend:     true,
+				}
+			}
+		}
+
+		for outIdx, out := range tx.Outputs {
+			key := addrIndexKey(out.PubKeyHash, block.Height, txPos, eventFunding, outIdx)
+			events[string(key)] = &AddressEvent{
+				Height:    block.Height,
+				BlockHash: block.Hash,
+				TxID:      tx.ID,
+				Index:     outIdx,
+				Value:     out.Value,
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// indexAddresses adds the events of a connected block to the address index.
+func indexAddresses(txn *badger.Txn, block *Block, spent []UtxoEntry) error {
+	events, err := addressEvents(block, spent)
+	if err != nil {
+		return err
+	}
+
+	for key, event := range events {
+		err = txn.Set([]byte(key), event.serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexAddresses removes the events of a disconnected block from the
+// address index.
+func unindexAddresses(txn *badger.Txn, block *Block, spent []UtxoEntry) error {
+	events, err := addressEvents(block, spent)
+	if err != nil {
+		return err
+	}
+
+	for key := range events {
+		err = txn.Delete([]byte(key))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// spentOutputs looks up the outputs spent by a block of the active chain in
+// the transactions they come from, for blocks without undo record.
+func (chain *BlockChain) spentOutputs(block *Block) ([]UtxoEntry, error) {
+	var spent []UtxoEntry
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, in := range tx.Inputs {
+			prevTX, err := chain.FindTransaction(in.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			if in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+				return nil, errors.New("input refers to a missing output")
+			}
+			spent = append(spent, UtxoEntry{Output: prevTX.Outputs[in.Out]})
+		}
+	}
+
+	return spent, nil
+}
+
+// HasAddrIndex reports whether the address index is enabled.
+func (chain *BlockChain) HasAddrIndex() bool {
+	return chain.addrIndex
+}
+
+// BuildAddrIndex indexes the outputs and inputs of the whole active chain by
+// address and enables the index, which is then kept up to date as blocks
+// connect and disconnect. It returns the number of events indexed.
+func (chain *BlockChain) BuildAddrIndex() (int, error) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	chain.addrIndex = false
+	UTXOSet := UTXOSet{chain}
+	UTXOSet.DeleteByPrefix(addrIndexPrefix)
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(addrIndexEnabledKey)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	wb := chain.Database.NewWriteBatch()
+	defer wb.Cancel()
+
+	// the outputs created so far, to value the inputs spending them
+	outputs := make(map[string]TxOutput)
+
+	count := 0
+	for _, hash := range chain.GetBlockHashes(0, chain.GetBestHeight()) {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return 0, err
+		}
+
+		var spent []UtxoEntry
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Inputs {
+					key := outpointKey(in.ID, in.Out)
+					out, ok := outputs[key]
+					if !ok {
+						return 0, errors.New("input refers to a missing output")
+					}
+					delete(outputs, key)
+
+					spent = append(spent, UtxoEntry{Output: out})
+				}
+			}
+
+			for outIdx, out := range tx.Outputs {
+				outputs[outpointKey(tx.ID, outIdx)] = out
+			}
+		}
+
+		events, err := addressEvents(block, spent)
+		if err != nil {
+			return 0, err
+		}
+
+		for key, event := range events {
+			err = wb.Set([]byte(key), event.serialize())
+			if err != nil {
+				return 0, err
+			}
+			count++
+		}
+	}
+
+	err = wb.Set(addrIndexEnabledKey, []byte{1})
+	if err != nil {
+		return 0, err
+	}
+
+	err = wb.Flush()
+	if err != nil {
+		return 0, err
+	}
+
+	chain.addrIndex = true
+
+	return count, nil
+}
+
+// GetAddressHistory returns the outputs paying to pubKeyHash in the active
+// chain and the inputs spending them, in chain order.
+func (chain *BlockChain) GetAddressHistory(pubKeyHash []byte) ([]AddressEvent, error) {
+	if !chain.addrIndex {
+		return nil, ErrNoAddrIndex
+	}
+
+	var events []AddressEvent
+	prefix := addrIndexAddressPrefix(pubKeyHash)
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var event AddressEvent
+			err := it.Item().Value(func(val []byte) error {
+				return event.deserialize(val)
+			})
+			if err != nil {
+				return err
+			}
+
+			events = append(events, event)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// GetAddressBalanceHistory returns the balance of pubKeyHash after every
+// block of the active chain touching it.
+func (chain *BlockChain) GetAddressBalanceHistory(pubKeyHash []byte) ([]AddressBalance, error) {
+	events, err := chain.GetAddressHistory(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []AddressBalance
+	balance := 0
+
+	for _, event := range events {
+		if event.Spend {
+			balance -= event.Value
+		} else {
+			balance += event.Value
+		}
+
+		last := len(history) - 1
+		if last >= 0 && history[last].Height == event.Height {
+			history[last].Balance = balance
+			continue
+		}
+
+		history = append(history, AddressBalance{
+			Height:    event.Height,
+			BlockHash: event.BlockHash,
+			Balance:   balance,
+		})
+	}
+
+	return history, nil
+}
@@ -1,59 +1,81 @@
-package blockchain
-
-import (
-	"time"
-
-	"github.com/dev-rodrigobaliza/go-blockchain/utils"
-	"github.com/goccy/go-json"
-)
-
-type Block struct {
-	Timestamp    int64
-	Hash         []byte        `json:"hash,omitempty"`
-	Transactions []Transaction `json:"transactions,omitempty"`
-	PrevHash     []byte        `json:"prev_hash,omitempty"`
-	Nonce        int
-	Height       int
-}
-
-func NewBlock(txs []Transaction, prevHash []byte, height int) Block {
-	block := Block{time.Now().Unix(), []byte{}, txs, prevHash, 0, height}
-	pow := NewProof(block)
-	nonce, hash := pow.Run()
-
-	block.Hash = hash[:]
-	block.Nonce = nonce
-
-	return block
-}
-
-func Genesis(coinbase Transaction) Block {
-	return NewBlock([]Transaction{coinbase}, []byte{}, 0)
-}
-
-func (b Block) HashTransactions() []byte {
-	var txHashes [][]byte
-
-	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.Serialize())
-	}
-	tree := NewMerkleTree(txHashes)
-
-	return tree.RootNode.Data
-}
-
-func (b Block) Serialize() []byte {
-	buffer, err := json.Marshal(b)
-	utils.Handle(err)
-
-	return buffer
-}
-
-func (b Block) Deserialize(buffer []byte) error {
-	err := json.Unmarshal(buffer, b)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
+package blockchain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	"github.com/goccy/go-json"
+)
+
+type Block struct {
+	BlockHeader  `json:"header"`
+	Hash         []byte        `json:"hash,omitempty"`
+	Transactions []Transaction `json:"transactions,omitempty"`
+}
+
+// ErrNonceSpaceExhausted is returned when no nonce gives the header of a
+// block a hash below its target.
+var ErrNonceSpaceExhausted = errors.New("no nonce gives a hash below the target")
+
+// NewBlock mines a block holding txs with the given header, filling in the
+// merkle root and the nonce.
+func NewBlock(header BlockHeader, txs []Transaction) (Block, error) {
+	block := Block{header, []byte{}, txs}
+	block.MerkleRoot = block.HashTransactions()
+
+	pow := NewProof(block.BlockHeader)
+	nonce, hash, found := pow.Run()
+	if !found {
+		return Block{}, ErrNonceSpaceExhausted
+	}
+
+	block.Hash = hash[:]
+	block.Nonce = nonce
+
+	return block, nil
+}
+
+func Genesis(coinbase Transaction, chainParams *params.ChainParams) (Block, error) {
+	header := BlockHeader{
+		Version:   BlockVersion,
+		PrevHash:  []byte{},
+		Timestamp: time.Now().Unix(),
+		Bits:      BigToCompact(chainParams.PowLimit),
+		Height:    0,
+	}
+
+	return NewBlock(header, []Transaction{coinbase})
+}
+
+// HashTransactions returns the merkle root of the transaction IDs.
+func (b Block) HashTransactions() []byte {
+	return b.merkleTree().RootNode.Data
+}
+
+func (b Block) merkleTree() *MerkleTree {
+	var txHashes [][]byte
+
+	for _, tx := range b.Transactions {
+		txHashes = append(txHashes, tx.ID)
+	}
+
+	return NewMerkleTree(txHashes)
+}
+
+func (b Block) Serialize() []byte {
+	buffer, err := json.Marshal(b)
+	utils.Handle(err)
+
+	return buffer
+}
+
+func (b *Block) Deserialize(buffer []byte) error {
+	err := json.Unmarshal(buffer, b)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
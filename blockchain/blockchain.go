@@ -1,370 +1,641 @@
-package blockchain
-
-import (
-	"bytes"
-	"crypto/ecdsa"
-	"encoding/hex"
-	"errors"
-	"fmt"
-	"runtime"
-
-	"github.com/dev-rodrigobaliza/go-blockchain/database"
-	"github.com/dev-rodrigobaliza/go-blockchain/utils"
-	"github.com/dgraph-io/badger"
-)
-
-const (
-	genesisData = "First Transaction from Genesis"
-	lastHashPrefix = "lh"
-)
-
-type BlockChain struct {
-	LastHash []byte
-	Database *badger.DB
-}
-
-func InitBlockChain(address, nodeId string) *BlockChain {
-	if database.DBexists(nodeId) {
-		fmt.Println("Blockchain already exists")
-		runtime.Goexit()
-	}
-
-	db := database.GetDB(nodeId)
-
-	var lastHash []byte
-	err := db.Update(func(txn *badger.Txn) error {
-		cbtx := CoinbaseTx(address, genesisData)
-		genesis := Genesis(cbtx)
-		fmt.Println("Genesis created")
-		err := txn.Set(genesis.Hash, genesis.Serialize())
-		utils.Handle(err)
-		err = txn.Set([]byte(lastHashPrefix), genesis.Hash)
-
-		lastHash = genesis.Hash
-
-		return err
-	})
-	utils.Handle(err)
-
-	blockChain := BlockChain{lastHash, db}
-
-	return &blockChain
-}
-
-func ContinueBlockChain(nodeId string) *BlockChain {
-	if !database.DBexists(nodeId) {
-		fmt.Println("No existing blockchain, create one!")
-		runtime.Goexit()
-	}
-
-	db := database.GetDB(nodeId)
-
-	var lastHash []byte
-	err := db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(lastHashPrefix))
-		utils.Handle(err)
-
-		err = item.Value(func(val []byte) error {
-			lastHash = append([]byte{}, val...)
-
-			return nil
-		})
-		return err
-	})
-	utils.Handle(err)
-
-	blockChain := BlockChain{lastHash, db}
-
-	return &blockChain
-}
-
-func (chain *BlockChain) AddBlock(block *Block) {
-	var lastHash []byte
-	var lastBlock *Block
-
-	err := chain.Database.Update(func(txn *badger.Txn) error {
-		_, err := txn.Get(block.Hash)
-		if err == nil {
-			return nil
-		}
-
-		blockData := block.Serialize()
-		err = txn.Set(block.Hash, blockData)
-		utils.Handle(err)
-
-		item, err := txn.Get([]byte(lastHashPrefix))
-		utils.Handle(err)
-
-		err = item.Value(func(val []byte) error {
-			lastHash = append([]byte{}, val...)
-
-			return nil
-		})
-		utils.Handle(err)
-
-		item, err = txn.Get(lastHash)
-		utils.Handle(err)
-
-		err = item.Value(func(val []byte) error {
-			lastBlock.Deserialize(val)
-
-			return nil
-		})
-		utils.Handle(err)
-
-		if block.Height > lastBlock.Height {
-			err = txn.Set([]byte(lastHashPrefix), block.Hash)
-			utils.Handle(err)
-
-			chain.LastHash = block.Hash
-		}
-
-		return nil
-	})
-	utils.Handle(err)
-}
-
-func (chain *BlockChain) GetBlock(blockHash []byte) (*Block, error) {
-	var block *Block
-
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(blockHash)
-		if err != nil {
-			return errors.New("block not found")
-		}
-
-		err = item.Value(func(val []byte) error {
-			block.Deserialize(val)
-
-			return nil
-		})
-
-		return err
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return block, nil
-}
-
-func (chain *BlockChain) GetBestHeight() int {
-	var lastHash []byte
-	var lastBlock *Block
-	var lastHeight int
-
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(lastHashPrefix))
-		utils.Handle(err)
-
-		err = item.Value(func(val []byte) error {
-			lastHash = append([]byte{}, val...)
-
-			return nil
-		})
-		utils.Handle(err)
-
-		item, err = txn.Get(lastHash)
-		utils.Handle(err)
-
-		err = item.Value(func(val []byte) error {
-			lastBlock.Deserialize(val)
-
-			return nil
-		})
-		utils.Handle(err)
-
-		lastHeight = lastBlock.Height
-
-		return err
-	})
-	utils.Handle(err)
-
-	return lastHeight
-
-}
-
-func (chain *BlockChain) GetBlockHashes() [][]byte {
-	var blocks [][]byte
-
-	iter := chain.Iterator()
-
-	for {
-		block := iter.Next()
-
-		blocks = append(blocks, block.Hash)
-
-		if len(block.PrevHash) == 0 {
-			break
-		}
-	}
-
-	return blocks
-}
-
-func (chain *BlockChain) MineBlock(transactions []Transaction) Block {
-	var lastHash []byte
-
-	lastHeight := chain.GetBestHeight()
-	lastHeight++
-	newBlock := NewBlock(transactions, lastHash, lastHeight)
-
-	err := chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		utils.Handle(err)
-
-		err = txn.Set([]byte(lastHashPrefix), newBlock.Hash)
-
-		chain.LastHash = newBlock.Hash
-
-		return err
-	})
-	utils.Handle(err)
-
-	return newBlock
-}
-
-func (chain *BlockChain) Iterator() *BlockChainIterator {
-	iter := &BlockChainIterator{chain.LastHash, chain.Database}
-
-	return iter
-}
-
-func (chain *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
-	var unspentTxs []Transaction
-
-	spentTXOs := make(map[string][]int)
-
-	iter := chain.Iterator()
-
-	for {
-		block := iter.Next()
-
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for outIdx, out := range tx.Outputs {
-				if spentTXOs[txID] != nil {
-					for _, spentOut := range spentTXOs[txID] {
-						if spentOut == outIdx {
-							continue Outputs
-						}
-					}
-				}
-
-				if out.IsLockedWithKey(pubKeyHash) {
-					unspentTxs = append(unspentTxs, tx)
-				}
-			}
-
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Inputs {
-					if in.UsesKey(pubKeyHash) {
-						inTXID := hex.EncodeToString(in.ID)
-						spentTXOs[inTXID] = append(spentTXOs[inTXID], in.Out)
-					}
-				}
-			}
-		}
-
-		if len(block.PrevHash) == 0 {
-			break
-		}
-	}
-
-	return unspentTxs
-}
-
-func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
-	UTXO := make(map[string]TxOutputs)
-	spentTXOs := make(map[string][]int)
-
-	iter := chain.Iterator()
-
-	for {
-		block := iter.Next()
-
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for outIdx, out := range tx.Outputs {
-				if spentTXOs[txID] != nil {
-					for _, spentOutIdx := range spentTXOs[txID] {
-						if spentOutIdx == outIdx {
-							continue Outputs
-						}
-					}
-				}
-
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
-			}
-
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Inputs {
-					inTxID := hex.EncodeToString(in.ID)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Out)
-				}
-			}
-		}
-
-		if len(block.PrevHash) == 0 {
-			break
-		}
-	}
-
-	return UTXO
-}
-
-func (chain *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
-	iter := chain.Iterator()
-
-	for {
-		block := iter.Next()
-
-		for _, tx := range block.Transactions {
-			if bytes.Equal(tx.ID, ID) {
-				return tx, nil
-			}
-		}
-
-		if len(block.PrevHash) == 0 {
-			break
-		}
-	}
-
-	return Transaction{}, errors.New("Transaction does not exist")
-}
-
-func (chain *BlockChain) SignTransaction(tx Transaction, privKey ecdsa.PrivateKey) {
-	prevTXs := make(map[string]Transaction)
-
-	for _, in := range tx.Inputs {
-		prevTX, err := chain.FindTransaction(in.ID)
-		utils.Handle(err)
-
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
-	}
-
-	tx.Sign(&privKey, prevTXs)
-}
-
-func (chain *BlockChain) VerifyTransaction(tx Transaction) bool {
-	if tx.IsCoinbase() {
-		return true
-	}
-
-	prevTXs := make(map[string]Transaction)
-
-	for _, in := range tx.Inputs {
-		prevTX, err := chain.FindTransaction(in.ID)
-		utils.Handle(err)
-
-		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
-	}
-
-	return tx.Verify(prevTXs)
-}
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/database"
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	"github.com/dgraph-io/badger"
+)
+
+const (
+	lastHashPrefix = "lh"
+)
+
+// ErrOrphanBlock is returned by AddBlock when the parent of a block is not
+// known, so the block cannot be linked to any branch.
+var ErrOrphanBlock = errors.New("block parent is unknown")
+
+type BlockChain struct {
+	// LastHash is the hash of the tip of the active chain. It only changes
+	// while mu is held, and other goroutines read it with Tip.
+	LastHash []byte
+	Database *badger.DB
+	Params   *params.ChainParams
+
+	mu        sync.Mutex
+	tipMu     sync.RWMutex
+	txIndex   bool
+	addrIndex bool
+}
+
+func InitBlockChain(address, nodeId string, chainParams *params.ChainParams) *BlockChain {
+	if database.DBexists(chainParams.Name, nodeId) {
+		fmt.Println("Blockchain already exists")
+		runtime.Goexit()
+	}
+
+	db := database.GetDB(chainParams.Name, nodeId)
+
+	var lastHash []byte
+	err := db.Update(func(txn *badger.Txn) error {
+		cbtx := CoinbaseTx(address, chainParams.GenesisMessage, CalcBlockSubsidy(0, chainParams))
+		genesis, err := Genesis(cbtx, chainParams)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Genesis created")
+		err = txn.Set(genesis.Hash, genesis.Serialize())
+		utils.Handle(err)
+		err = putBlockNode(txn, newBlockNode(&genesis.BlockHeader, genesis.Hash, nil))
+		utils.Handle(err)
+		err = txn.Set(heightIndexKey(0), genesis.Hash)
+		utils.Handle(err)
+		err = txn.Set([]byte(lastHashPrefix), genesis.Hash)
+
+		lastHash = genesis.Hash
+
+		return err
+	})
+	utils.Handle(err)
+
+	blockChain := BlockChain{LastHash: lastHash, Database: db, Params: chainParams}
+
+	return &blockChain
+}
+
+func ContinueBlockChain(nodeId string, chainParams *params.ChainParams) *BlockChain {
+	if !database.DBexists(chainParams.Name, nodeId) {
+		fmt.Println("No existing blockchain, create one!")
+		runtime.Goexit()
+	}
+
+	db := database.GetDB(chainParams.Name, nodeId)
+
+	var lastHash []byte
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(lastHashPrefix))
+		if err != nil {
+			return err
+		}
+
+		err = item.Value(func(val []byte) error {
+			lastHash = append([]byte{}, val...)
+
+			return nil
+		})
+		return err
+	})
+	utils.Handle(err)
+
+	blockChain := BlockChain{LastHash: lastHash, Database: db, Params: chainParams}
+
+	// the block index cannot be rebuilt for a database written before it,
+	// whose blocks do not carry the headers it is made of
+	_, err = blockChain.getBlockNode(lastHash)
+	if err != nil {
+		fmt.Println("The blockchain was written by an older version without a block index, create it again!")
+		db.Close()
+		runtime.Goexit()
+	}
+
+	err = blockChain.buildHeightIndex()
+	utils.Handle(err)
+
+	err = blockChain.loadIndexState()
+	utils.Handle(err)
+
+	UTXOSet := UTXOSet{&blockChain}
+	if UTXOSet.Migrate() {
+		fmt.Println("UTXO set migrated to one key per output")
+	}
+
+	return &blockChain
+}
+
+// AddBlock validates and stores a block and, when its branch carries more
+// cumulative work than the active chain, reorganizes the active chain onto
+// it. Blocks on weaker branches are kept so they can win later. The returned
+// slices hold the blocks removed from and added to the active chain, in the
+// order they were applied. A RuleError is returned when the block, or a
+// block of the branch it completes, breaks a consensus rule.
+func (chain *BlockChain) AddBlock(block *Block) ([]Block, []Block, error) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	if chain.HasBlock(block.Hash) {
+		return nil, nil, nil
+	}
+
+	err := chain.ValidateBlock(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parent, err := chain.getBlockNode(block.PrevHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	node := newBlockNode(&block.BlockHeader, block.Hash, parent)
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		err := txn.Set(block.Hash, block.Serialize())
+		if err != nil {
+			return err
+		}
+
+		return putBlockNode(txn, node)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// ValidateBlock already checked the transactions of a block extending
+	// the active chain, so it is connected right away
+	if bytes.Equal(block.PrevHash, chain.Tip()) {
+		UTXOSet := UTXOSet{chain}
+
+		err = UTXOSet.ConnectBlock(block)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, []Block{*block}, nil
+	}
+
+	tip, err := chain.getBlockNode(chain.Tip())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if node.ChainWork.Cmp(tip.ChainWork) <= 0 {
+		return nil, nil, nil
+	}
+
+	return chain.reorganize(tip, node)
+}
+
+// reorganize moves the active chain from oldTip to newTip. If a block of the
+// new branch turns out to be invalid, the branch is cut there and the chain
+// goes back to oldTip unless what was connected already has more work.
+func (chain *BlockChain) reorganize(oldTip, newTip *blockNode) ([]Block, []Block, error) {
+	ruleErr := chain.switchTip(oldTip, newTip)
+	if _, ok := ruleErr.(RuleError); ruleErr != nil && !ok {
+		return nil, nil, ruleErr
+	}
+
+	tip, err := chain.getBlockNode(chain.Tip())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ruleErr != nil && oldTip.ChainWork.Cmp(tip.ChainWork) > 0 {
+		err = chain.switchTip(tip, oldTip)
+		if err != nil {
+			return nil, nil, err
+		}
+		tip = oldTip
+	}
+
+	fork, err := chain.findFork(oldTip, tip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	detach, err := chain.blocksAfter(fork, oldTip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	attach, err := chain.blocksAfter(fork, tip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// report disconnected blocks from the old tip down
+	for i, j := 0, len(detach)-1; i < j; i, j = i+1, j-1 {
+		detach[i], detach[j] = detach[j], detach[i]
+	}
+
+	return detach, attach, ruleErr
+}
+
+// switchTip disconnects the blocks of the active chain above the fork point
+// of oldTip and newTip, then validates and connects the blocks leading to
+// newTip. When a block fails validation it and the rest of its branch are
+// marked invalid, and the active chain is left at its parent.
+func (chain *BlockChain) switchTip(oldTip, newTip *blockNode) error {
+	fork, err := chain.findFork(oldTip, newTip)
+	if err != nil {
+		return err
+	}
+
+	attach, err := chain.blocksAfter(fork, newTip)
+	if err != nil {
+		return err
+	}
+
+	UTXOSet := UTXOSet{chain}
+
+	if !bytes.Equal(fork.Hash, oldTip.Hash) {
+		err = chain.disconnectTo(fork)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, block := range attach {
+		err = chain.checkConnectBlock(&block)
+		if err != nil {
+			markErr := chain.markInvalid(attach[i:])
+			if markErr != nil {
+				return markErr
+			}
+
+			return err
+		}
+
+		err = UTXOSet.ConnectBlock(&block)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disconnectTo disconnects the blocks of the active chain down to fork. The
+// blocks connected before undo records were kept cannot be disconnected one
+// by one, so the UTXO set is rebuilt at fork when one of them is reached.
+func (chain *BlockChain) disconnectTo(fork *blockNode) error {
+	UTXOSet := UTXOSet{chain}
+
+	for !bytes.Equal(chain.Tip(), fork.Hash) {
+		block, err := chain.GetBlock(chain.Tip())
+		if err != nil {
+			return err
+		}
+
+		err = UTXOSet.DisconnectBlock(block)
+		if err == ErrNoUndoData {
+			err = chain.rewindTip(fork)
+			if err != nil {
+				return err
+			}
+			UTXOSet.Reindex()
+
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// blocksAfter returns the blocks from fork (excluded) up to tip (included) in
+// ascending height order.
+func (chain *BlockChain) blocksAfter(fork, tip *blockNode) ([]Block, error) {
+	blocks := make([]Block, tip.Height-fork.Height)
+
+	hash := tip.Hash
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks[i] = *block
+		hash = block.PrevHash
+	}
+
+	return blocks, nil
+}
+
+// rewindTip makes fork, an ancestor of the tip, the tip of the active chain
+// and drops the blocks above it from the height, transaction and address
+// indexes. The UTXO set is left untouched.
+func (chain *BlockChain) rewindTip(fork *blockNode) error {
+	tip, err := chain.getBlockNode(chain.Tip())
+	if err != nil {
+		return err
+	}
+
+	var detached []Block
+	if chain.txIndex || chain.addrIndex {
+		detached, err = chain.blocksAfter(fork, tip)
+		if err != nil {
+			return err
+		}
+	}
+
+	var detachedSpent [][]UtxoEntry
+	if chain.addrIndex {
+		for _, block := range detached {
+			spent, err := chain.spentOutputs(&block)
+			if err != nil {
+				return err
+			}
+			detachedSpent = append(detachedSpent, spent)
+		}
+	}
+
+	err = chain.Database.Update(func(txn *badger.Txn) error {
+		for height := fork.Height + 1; height <= tip.Height; height++ {
+			err := txn.Delete(heightIndexKey(height))
+			if err != nil {
+				return err
+			}
+		}
+
+		for i, block := range detached {
+			if chain.txIndex {
+				err := unindexTransactions(txn, &block)
+				if err != nil {
+					return err
+				}
+			}
+
+			if chain.addrIndex {
+				err := unindexAddresses(txn, &block, detachedSpent[i])
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return txn.Set([]byte(lastHashPrefix), fork.Hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	chain.setTip(fork.Hash)
+
+	return nil
+}
+
+func (chain *BlockChain) HasBlock(blockHash []byte) bool {
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(blockHash)
+
+		return err
+	})
+
+	return err == nil
+}
+
+func (chain *BlockChain) GetBlock(blockHash []byte) (*Block, error) {
+	var block Block
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(blockHash)
+		if err != nil {
+			return errors.New("block not found")
+		}
+
+		return item.Value(func(val []byte) error {
+			return block.Deserialize(val)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &block, nil
+}
+
+// Tip returns the hash of the tip of the active chain. It is safe to call
+// while blocks are being added.
+func (chain *BlockChain) Tip() []byte {
+	chain.tipMu.RLock()
+	defer chain.tipMu.RUnlock()
+
+	return chain.LastHash
+}
+
+// setTip makes hash the tip of the active chain. The caller holds mu.
+func (chain *BlockChain) setTip(hash []byte) {
+	chain.tipMu.Lock()
+	defer chain.tipMu.Unlock()
+
+	chain.LastHash = hash
+}
+
+// GetBestHeight returns the height of the tip of the active chain, whose
+// block index entry ContinueBlockChain makes sure exists.
+func (chain *BlockChain) GetBestHeight() int {
+	tip, err := chain.getBlockNode(chain.Tip())
+	utils.Handle(err)
+
+	return tip.Height
+}
+
+// NextBlockHeader returns the header of a block on top of the active chain,
+// with the difficulty it must use and a timestamp past the median time of
+// its ancestors. The merkle root and the nonce are left for the miner.
+func (chain *BlockChain) NextBlockHeader() (BlockHeader, error) {
+	tip, err := chain.getBlockNode(chain.Tip())
+	if err != nil {
+		return BlockHeader{}, err
+	}
+
+	bits, err := chain.calcNextRequiredBits(tip)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+
+	medianTime, err := chain.medianTimePast(tip)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+
+	timestamp := time.Now().Unix()
+	if timestamp <= medianTime {
+		timestamp = medianTime + 1
+	}
+
+	header := BlockHeader{
+		Version:   BlockVersion,
+		PrevHash:  tip.Hash,
+		Timestamp: timestamp,
+		Bits:      bits,
+		Height:    tip.Height + 1,
+	}
+
+	return header, nil
+}
+
+// MineBlock mines a block with the given transactions on top of the active
+// chain and adds it through the same validation as blocks from the network.
+func (chain *BlockChain) MineBlock(transactions []Transaction) (Block, error) {
+	header, err := chain.NextBlockHeader()
+	if err != nil {
+		return Block{}, err
+	}
+
+	newBlock, err := NewBlock(header, transactions)
+	if err != nil {
+		return Block{}, err
+	}
+
+	_, _, err = chain.AddBlock(&newBlock)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return newBlock, nil
+}
+
+func (chain *BlockChain) Iterator() *BlockChainIterator {
+	iter := &BlockChainIterator{chain.Tip(), chain.Database}
+
+	return iter
+}
+
+func (chain *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
+	var unspentTxs []Transaction
+
+	spentTXOs := make(map[string][]int)
+
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		for _, tx := range block.Transactions {
+			txID := hex.EncodeToString(tx.ID)
+
+		Outputs:
+			for outIdx, out := range tx.Outputs {
+				if spentTXOs[txID] != nil {
+					for _, spentOut := range spentTXOs[txID] {
+						if spentOut == outIdx {
+							continue Outputs
+						}
+					}
+				}
+
+				if out.IsLockedWithKey(pubKeyHash) {
+					unspentTxs = append(unspentTxs, tx)
+				}
+			}
+
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Inputs {
+					if in.UsesKey(pubKeyHash) {
+						inTXID := hex.EncodeToString(in.ID)
+						spentTXOs[inTXID] = append(spentTXOs[inTXID], in.Out)
+					}
+				}
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return unspentTxs
+}
+
+// FindUTXO walks the active chain from the tip and returns the outputs not
+// spent by any later transaction, keyed by their key in the UTXO set.
+func (chain *BlockChain) FindUTXO() map[string]UtxoEntry {
+	UTXO := make(map[string]UtxoEntry)
+	spentTXOs := make(map[string]bool)
+
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		for _, tx := range block.Transactions {
+			for outIdx, out := range tx.Outputs {
+				if spentTXOs[outpointKey(tx.ID, outIdx)] {
+					continue
+				}
+
+				UTXO[string(utxoKey(tx.ID, outIdx))] = UtxoEntry{
+					Output:   out,
+					Coinbase: tx.IsCoinbase(),
+					Height:   block.Height,
+				}
+			}
+
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Inputs {
+					spentTXOs[outpointKey(in.ID, in.Out)] = true
+				}
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return UTXO
+}
+
+// FindTransaction returns a transaction of the active chain, through the
+// transaction index when it is enabled.
+func (chain *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
+	tx, _, err := chain.LocateTransaction(ID)
+
+	return tx, err
+}
+
+func (chain *BlockChain) SignTransaction(tx Transaction, privKey ecdsa.PrivateKey) {
+	prevTXs, err := chain.findPrevTransactions(&tx, nil)
+	utils.Handle(err)
+
+	tx.Sign(&privKey, prevTXs)
+}
+
+func (chain *BlockChain) VerifyTransaction(tx Transaction) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+
+	prevTXs, err := chain.findPrevTransactions(&tx, nil)
+	if err != nil {
+		return false
+	}
+
+	return tx.Verify(prevTXs)
+}
+
+// findPrevTransactions collects the transactions whose outputs are spent by
+// tx, looking first at the pending ones, keyed by hex ID, and then at the
+// active chain.
+func (chain *BlockChain) findPrevTransactions(tx *Transaction, pending map[string]Transaction) (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
+
+	for _, in := range tx.Inputs {
+		inID := hex.EncodeToString(in.ID)
+		if prevTX, ok := pending[inID]; ok {
+			prevTXs[inID] = prevTX
+			continue
+		}
+
+		prevTX, err := chain.FindTransaction(in.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		prevTXs[inID] = prevTX
+	}
+
+	return prevTXs, nil
+}
@@ -0,0 +1,95 @@
+package blockchain
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+	"github.com/dev-rodrigobaliza/go-blockchain/wallet"
+)
+
+// newBranchBlock mines a block paying to address on top of prev, which does
+// not have to be the tip of the active chain.
+func newBranchBlock(t *testing.T, chain *BlockChain, prev []byte, address string) Block {
+	t.Helper()
+
+	parent, err := chain.getBlockNode(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bits, err := chain.calcNextRequiredBits(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := BlockHeader{
+		Version:   BlockVersion,
+		PrevHash:  prev,
+		Timestamp: parent.Timestamp + 1,
+		Bits:      bits,
+		Height:    parent.Height + 1,
+	}
+
+	block, err := NewBlock(header, []Transaction{CoinbaseTx(address, "", CalcBlockSubsidy(header.Height, &params.RegTest))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+func TestReorganizeRestoresUTXOSet(t *testing.T) {
+	owner := wallet.NewWallet()
+	chain := newTestChain(t, owner)
+	UTXOSet := UTXOSet{chain}
+	address := string(owner.Address(&params.RegTest))
+
+	genesis, err := chain.GetBlock(chain.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := genesis.Transactions[0]
+
+	// the active chain spends the genesis coinbase
+	_, err = chain.MineBlock([]Transaction{CoinbaseTx(address, "", CalcBlockSubsidy(1, &params.RegTest))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := spendCoinbase(chain, owner, coinbase)
+	_, err = chain.MineBlock([]Transaction{CoinbaseTx(address, "", CalcBlockSubsidy(2, &params.RegTest)), tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a longer branch from genesis leaves it unspent
+	prev := genesis.Hash
+	var detached, attached []Block
+	for i := 0; i < 3; i++ {
+		block := newBranchBlock(t, chain, prev, address)
+		detached, attached, err = chain.AddBlock(&block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev = block.Hash
+	}
+
+	if len(detached) != 2 || len(attached) != 3 {
+		t.Fatalf("reorganization detached %d and attached %d blocks, want 2 and 3", len(detached), len(attached))
+	}
+	if !bytes.Equal(chain.Tip(), prev) {
+		t.Fatalf("tip is %x, want the branch tip %x", chain.Tip(), prev)
+	}
+
+	entries := utxoEntries(&UTXOSet)
+	if !reflect.DeepEqual(entries, chain.FindUTXO()) {
+		t.Errorf("UTXO set after the reorganization does not match the active chain")
+	}
+	if _, ok := entries[string(utxoKey(coinbase.ID, 0))]; !ok {
+		t.Error("genesis coinbase spent on the old branch is not back in the UTXO set")
+	}
+	if _, ok := entries[string(utxoKey(tx.ID, 0))]; ok {
+		t.Error("output created on the old branch is still in the UTXO set")
+	}
+}
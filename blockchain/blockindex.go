@@ -0,0 +1,167 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	"github.com/dgraph-io/badger"
+	"github.com/goccy/go-json"
+)
+
+var (
+	blockIndexPrefix = []byte("bi-")
+
+	// oneLsh256 is 1 shifted left 256 bits, used to turn a target into work.
+	oneLsh256 = new(big.Int).Lsh(big.NewInt(1), 256)
+)
+
+// blockStatus records what is known about the validity of a stored block.
+type blockStatus byte
+
+const (
+	// statusInvalid marks a block that failed validation, or that descends
+	// from one that did.
+	statusInvalid blockStatus = 1 << iota
+
+	// statusHeaderOnly marks an entry added from a header whose block has
+	// not been downloaded yet.
+	statusHeaderOnly
+)
+
+func (s blockStatus) KnownInvalid() bool {
+	return s&statusInvalid != 0
+}
+
+// HaveData reports whether the block itself is stored, not only its header.
+func (s blockStatus) HaveData() bool {
+	return s&statusHeaderOnly == 0
+}
+
+// blockNode is the entry kept in the block index for every stored block,
+// whether it belongs to the active chain or to a side branch, and for every
+// accepted header whose block is still being downloaded. It carries the
+// header so ancestors can be inspected without loading their transactions.
+type blockNode struct {
+	BlockHeader `json:"header"`
+	Hash        []byte      `json:"hash"`
+	ChainWork   *big.Int    `json:"chain_work"`
+	Status      blockStatus `json:"status,omitempty"`
+}
+
+func newBlockNode(header *BlockHeader, hash []byte, parent *blockNode) *blockNode {
+	work := CalcWork(CompactToBig(header.Bits))
+	if parent != nil {
+		work.Add(work, parent.ChainWork)
+	}
+
+	return &blockNode{
+		BlockHeader: *header,
+		Hash:        hash,
+		ChainWork:   work,
+	}
+}
+
+// CalcWork returns the expected number of hashes needed to find a block
+// below the given target.
+func CalcWork(target *big.Int) *big.Int {
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+
+	return new(big.Int).Div(oneLsh256, denominator)
+}
+
+func blockIndexKey(hash []byte) []byte {
+	return append(append([]byte{}, blockIndexPrefix...), hash...)
+}
+
+func (n *blockNode) serialize() []byte {
+	buffer, err := json.Marshal(n)
+	utils.Handle(err)
+
+	return buffer
+}
+
+func (n *blockNode) deserialize(buffer []byte) error {
+	return json.Unmarshal(buffer, n)
+}
+
+func putBlockNode(txn *badger.Txn, node *blockNode) error {
+	return txn.Set(blockIndexKey(node.Hash), node.serialize())
+}
+
+// markInvalid flags the given blocks as invalid in the block index.
+func (chain *BlockChain) markInvalid(blocks []Block) error {
+	return chain.Database.Update(func(txn *badger.Txn) error {
+		for _, block := range blocks {
+			item, err := txn.Get(blockIndexKey(block.Hash))
+			if err != nil {
+				return err
+			}
+
+			var node blockNode
+			err = item.Value(func(val []byte) error {
+				return node.deserialize(val)
+			})
+			if err != nil {
+				return err
+			}
+
+			node.Status |= statusInvalid
+			err = putBlockNode(txn, &node)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (chain *BlockChain) getBlockNode(hash []byte) (*blockNode, error) {
+	var node blockNode
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(blockIndexKey(hash))
+		if err != nil {
+			return errors.New("block index entry not found")
+		}
+
+		return item.Value(func(val []byte) error {
+			return node.deserialize(val)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &node, nil
+}
+
+// findFork returns the most recent block both nodes have in common.
+func (chain *BlockChain) findFork(a, b *blockNode) (*blockNode, error) {
+	var err error
+
+	for a.Height > b.Height {
+		if a, err = chain.getBlockNode(a.PrevHash); err != nil {
+			return nil, err
+		}
+	}
+
+	for b.Height > a.Height {
+		if b, err = chain.getBlockNode(b.PrevHash); err != nil {
+			return nil, err
+		}
+	}
+
+	for !bytes.Equal(a.Hash, b.Hash) {
+		if a, err = chain.getBlockNode(a.PrevHash); err != nil {
+			return nil, err
+		}
+		if b, err = chain.getBlockNode(b.PrevHash); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
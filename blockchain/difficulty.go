@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+const (
+	// retargetAdjustmentFactor bounds how much a single adjustment can make
+	// the target easier or harder.
+	retargetAdjustmentFactor = 4
+
+	// medianTimeBlocks is the number of blocks used for the median time
+	// past a new block timestamp must exceed.
+	medianTimeBlocks = 11
+)
+
+// NextRequiredBits returns the difficulty bits the next block on top of the
+// active chain must use.
+func (chain *BlockChain) NextRequiredBits() (uint32, error) {
+	tip, err := chain.getBlockNode(chain.Tip())
+	if err != nil {
+		return 0, err
+	}
+
+	return chain.calcNextRequiredBits(tip)
+}
+
+// calcNextRequiredBits returns the difficulty bits of a block built on
+// parent. The target only moves on the first block of a retarget window,
+// where it is scaled by how long the previous window actually took compared
+// to the target block interval of the chain. A window shorter than two
+// blocks, or an interval under a second, leaves nothing to measure and is
+// reported as an error.
+func (chain *BlockChain) calcNextRequiredBits(parent *blockNode) (uint32, error) {
+	if chain.Params.NoRetargeting {
+		return parent.Bits, nil
+	}
+
+	window := chain.Params.RetargetWindow
+	if window < 2 {
+		return 0, fmt.Errorf("retarget window of %d blocks is too short", window)
+	}
+
+	if (parent.Height+1)%window != 0 {
+		return parent.Bits, nil
+	}
+
+	first, err := chain.ancestor(parent, parent.Height-window)
+	if err != nil {
+		return 0, err
+	}
+
+	blocks := int64(parent.Height - first.Height)
+	targetTimespan := blocks * int64(chain.Params.TargetBlockInterval/time.Second)
+	if targetTimespan <= 0 {
+		return 0, fmt.Errorf("target block interval of %v is too short to retarget", chain.Params.TargetBlockInterval)
+	}
+
+	minTimespan := targetTimespan / retargetAdjustmentFactor
+	maxTimespan := targetTimespan * retargetAdjustmentFactor
+
+	actualTimespan := parent.Timestamp - first.Timestamp
+	if actualTimespan < minTimespan {
+		actualTimespan = minTimespan
+	} else if actualTimespan > maxTimespan {
+		actualTimespan = maxTimespan
+	}
+
+	newTarget := CompactToBig(parent.Bits)
+	newTarget.Mul(newTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimespan))
+
+	if newTarget.Cmp(chain.Params.PowLimit) > 0 {
+		newTarget.Set(chain.Params.PowLimit)
+	}
+
+	return BigToCompact(newTarget), nil
+}
+
+// ancestor walks back from node to the block at the given height, or to the
+// genesis block when height is negative.
+func (chain *BlockChain) ancestor(node *blockNode, height int) (*blockNode, error) {
+	var err error
+
+	if height < 0 {
+		height = 0
+	}
+
+	for node.Height > height {
+		node, err = chain.getBlockNode(node.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+// medianTimePast returns the median timestamp of the last blocks up to and
+// including node.
+func (chain *BlockChain) medianTimePast(node *blockNode) (int64, error) {
+	var err error
+
+	timestamps := make([]int64, 0, medianTimeBlocks)
+	for i := 0; i < medianTimeBlocks; i++ {
+		timestamps = append(timestamps, node.Timestamp)
+
+		if len(node.PrevHash) == 0 {
+			break
+		}
+
+		node, err = chain.getBlockNode(node.PrevHash)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	return timestamps[len(timestamps)/2], nil
+}
+
+// CompactToBig expands the compact representation of a target stored in the
+// Bits field of a header. The compact form is a base 256 floating point
+// number: the high byte is the exponent and the low 23 bits the mantissa,
+// with bit 23 as the sign.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		target = big.NewInt(int64(mantissa))
+	} else {
+		target = big.NewInt(int64(mantissa))
+		target.Lsh(target, 8*(exponent-3))
+	}
+
+	if isNegative {
+		target = target.Neg(target)
+	}
+
+	return target
+}
+
+// BigToCompact encodes a target in the compact form used by headers. Only
+// the three most significant bytes are kept.
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Set(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Bits()[0])
+	}
+
+	// keep the sign bit clear by moving to a larger exponent
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+
+	return compact
+}
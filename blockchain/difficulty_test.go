@@ -0,0 +1,22 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+)
+
+func TestRetargetWindowTooShort(t *testing.T) {
+	for _, window := range []int{0, 1} {
+		chainParams := params.MainNet
+		chainParams.RetargetWindow = window
+		chain := &BlockChain{Params: &chainParams}
+
+		genesis := newBlockNode(&BlockHeader{Bits: BigToCompact(chainParams.PowLimit)}, []byte{1}, nil)
+
+		_, err := chain.calcNextRequiredBits(genesis)
+		if err == nil {
+			t.Errorf("calcNextRequiredBits accepted a retarget window of %d blocks", window)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package blockchain
+
+import "fmt"
+
+// ErrorCode identifies the consensus rule a block or transaction broke.
+type ErrorCode int
+
+const (
+	// ErrBadBlockHash indicates the stored hash of a block does not match
+	// the hash of its contents.
+	ErrBadBlockHash ErrorCode = iota
+
+	// ErrHighHash indicates the block hash is above the target.
+	ErrHighHash
+
+	// ErrBadMerkleRoot indicates the merkle root in the header does not
+	// match the transactions of the block.
+	ErrBadMerkleRoot
+
+	// ErrUnexpectedDifficulty indicates the difficulty bits in the header
+	// are not the ones the block must use.
+	ErrUnexpectedDifficulty
+
+	// ErrTimeTooOld indicates the block timestamp is not after the median
+	// time of the blocks before it.
+	ErrTimeTooOld
+
+	// ErrTimeTooNew indicates the block timestamp is too far in the future.
+	ErrTimeTooNew
+
+	// ErrBadHeight indicates the block height is not one more than the
+	// height of its parent.
+	ErrBadHeight
+
+	// ErrNoTransactions indicates the block has no transactions.
+	ErrNoTransactions
+
+	// ErrFirstTxNotCoinbase indicates the first transaction of a block is
+	// not a coinbase.
+	ErrFirstTxNotCoinbase
+
+	// ErrMultipleCoinbases indicates a block holds more than one coinbase.
+	ErrMultipleCoinbases
+
+	// ErrBadCoinbaseValue indicates the coinbase pays out more than allowed.
+	ErrBadCoinbaseValue
+
+	// ErrBadTxID indicates a transaction ID is not the hash of the
+	// transaction.
+	ErrBadTxID
+
+	// ErrDuplicateTx indicates a block holds the same transaction twice.
+	ErrDuplicateTx
+
+	// ErrNoTxInputs indicates a transaction has no inputs.
+	ErrNoTxInputs
+
+	// ErrNoTxOutputs indicates a transaction has no outputs.
+	ErrNoTxOutputs
+
+	// ErrBadTxOutValue indicates an output value is negative, zero outside
+	// of a coinbase, or that output values add up to more than the max
+	// supply.
+	ErrBadTxOutValue
+
+	// ErrMissingTxOut indicates an input refers to an output that does not
+	// exist or was already spent.
+	ErrMissingTxOut
+
+	// ErrDoubleSpend indicates an output is spent twice in the same block.
+	ErrDoubleSpend
+
+	// ErrSpendTooHigh indicates a transaction spends more than its inputs.
+	ErrSpendTooHigh
+
+	// ErrBadSignature indicates an input signature does not verify.
+	ErrBadSignature
+
+	// ErrLooseCoinbase indicates a coinbase was submitted on its own rather
+	// than as part of a block.
+	ErrLooseCoinbase
+
+	// ErrInvalidAncestor indicates a block descends from an invalid block.
+	ErrInvalidAncestor
+
+	// ErrBlockTooBig indicates the serialized block is larger than allowed.
+	ErrBlockTooBig
+
+	// ErrTooManySigOps indicates the block requires more signature checks
+	// than allowed.
+	ErrTooManySigOps
+
+	// ErrImmatureSpend indicates a transaction spends a coinbase output
+	// before it reached the coinbase maturity.
+	ErrImmatureSpend
+
+	// ErrWrongOwner indicates a transaction input supplies a public key that
+	// does not own the output it spends.
+	ErrWrongOwner
+)
+
+var errorCodeStrings = map[ErrorCode]string{
+	ErrBadBlockHash:         "ErrBadBlockHash",
+	ErrHighHash:             "ErrHighHash",
+	ErrBadMerkleRoot:        "ErrBadMerkleRoot",
+	ErrUnexpectedDifficulty: "ErrUnexpectedDifficulty",
+	ErrTimeTooOld:           "ErrTimeTooOld",
+	ErrTimeTooNew:           "ErrTimeTooNew",
+	ErrBadHeight:            "ErrBadHeight",
+	ErrNoTransactions:       "ErrNoTransactions",
+	ErrFirstTxNotCoinbase:   "ErrFirstTxNotCoinbase",
+	ErrMultipleCoinbases:    "ErrMultipleCoinbases",
+	ErrBadCoinbaseValue:     "ErrBadCoinbaseValue",
+	ErrBadTxID:              "ErrBadTxID",
+	ErrDuplicateTx:          "ErrDuplicateTx",
+	ErrNoTxInputs:           "ErrNoTxInputs",
+	ErrNoTxOutputs:          "ErrNoTxOutputs",
+	ErrBadTxOutValue:        "ErrBadTxOutValue",
+	ErrMissingTxOut:         "ErrMissingTxOut",
+	ErrDoubleSpend:          "ErrDoubleSpend",
+	ErrSpendTooHigh:         "ErrSpendTooHigh",
+	ErrBadSignature:         "ErrBadSignature",
+	ErrLooseCoinbase:        "ErrLooseCoinbase",
+	ErrInvalidAncestor:      "ErrInvalidAncestor",
+	ErrBlockTooBig:          "ErrBlockTooBig",
+	ErrTooManySigOps:        "ErrTooManySigOps",
+	ErrImmatureSpend:        "ErrImmatureSpend",
+	ErrWrongOwner:           "ErrWrongOwner",
+}
+
+func (e ErrorCode) String() string {
+	if s := errorCodeStrings[e]; s != "" {
+		return s
+	}
+
+	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
+}
+
+// RuleError is returned when a block or transaction breaks a consensus rule.
+type RuleError struct {
+	ErrorCode   ErrorCode
+	Description string
+}
+
+func (e RuleError) Error() string {
+	return e.Description
+}
+
+func ruleError(c ErrorCode, desc string) RuleError {
+	return RuleError{ErrorCode: c, Description: desc}
+}
@@ -0,0 +1,97 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// BlockVersion is the version written in the header of new blocks.
+	BlockVersion = 1
+
+	// HashLength is the length of block and transaction hashes.
+	HashLength = sha256.Size
+
+	// HeaderSize is the length of a serialized block header.
+	HeaderSize = 4 + HashLength + HashLength + 8 + 4 + 4 + 8
+)
+
+// BlockHeader holds everything the proof of work of a block commits to. The
+// transactions are committed through the merkle root.
+type BlockHeader struct {
+	Version    int32
+	PrevHash   []byte `json:"prev_hash,omitempty"`
+	MerkleRoot []byte `json:"merkle_root,omitempty"`
+	Timestamp  int64
+	Bits       uint32
+	Nonce      uint32
+	Height     int
+}
+
+// Serialize encodes the header in its canonical binary form. Hashes are
+// zero padded, so the genesis header, which has no previous hash, still has
+// a fixed length.
+func (h *BlockHeader) Serialize() []byte {
+	buffer := make([]byte, HeaderSize)
+	offset := 0
+
+	binary.BigEndian.PutUint32(buffer[offset:], uint32(h.Version))
+	offset += 4
+	copy(buffer[offset:offset+HashLength], h.PrevHash)
+	offset += HashLength
+	copy(buffer[offset:offset+HashLength], h.MerkleRoot)
+	offset += HashLength
+	binary.BigEndian.PutUint64(buffer[offset:], uint64(h.Timestamp))
+	offset += 8
+	binary.BigEndian.PutUint32(buffer[offset:], h.Bits)
+	offset += 4
+	binary.BigEndian.PutUint32(buffer[offset:], h.Nonce)
+	offset += 4
+	binary.BigEndian.PutUint64(buffer[offset:], uint64(h.Height))
+
+	return buffer
+}
+
+// Deserialize decodes a header written by Serialize.
+func (h *BlockHeader) Deserialize(buffer []byte) error {
+	if len(buffer) != HeaderSize {
+		return errors.New("invalid block header length")
+	}
+
+	offset := 0
+
+	h.Version = int32(binary.BigEndian.Uint32(buffer[offset:]))
+	offset += 4
+	h.PrevHash = decodeHash(buffer[offset : offset+HashLength])
+	offset += HashLength
+	h.MerkleRoot = decodeHash(buffer[offset : offset+HashLength])
+	offset += HashLength
+	h.Timestamp = int64(binary.BigEndian.Uint64(buffer[offset:]))
+	offset += 8
+	h.Bits = binary.BigEndian.Uint32(buffer[offset:])
+	offset += 4
+	h.Nonce = binary.BigEndian.Uint32(buffer[offset:])
+	offset += 4
+	h.Height = int(binary.BigEndian.Uint64(buffer[offset:]))
+
+	return nil
+}
+
+// BlockHash returns the hash of the serialized header, which identifies the
+// block and is checked against its target.
+func (h *BlockHeader) BlockHash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+
+	return hash[:]
+}
+
+// decodeHash turns an all zero hash back into an empty one.
+func decodeHash(buffer []byte) []byte {
+	if bytes.Equal(buffer, make([]byte, HashLength)) {
+		return []byte{}
+	}
+
+	return append([]byte{}, buffer...)
+}
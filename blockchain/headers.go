@@ -0,0 +1,62 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+// ErrUnconnectedHeaders is returned by ProcessHeaders when a header does not
+// build on a known block or header.
+var ErrUnconnectedHeaders = errors.New("headers do not connect to a known block")
+
+// ProcessHeaders validates headers, in ascending height order, and adds the
+// new ones to the block index ahead of their blocks. Each header must meet
+// its target and follow the difficulty and timestamp rules of its parent, so
+// a peer has to spend the work of a whole branch before its blocks are
+// downloaded. Headers that are already known are skipped.
+func (chain *BlockChain) ProcessHeaders(headers []BlockHeader) error {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	for i := range headers {
+		header := &headers[i]
+		hash := header.BlockHash()
+
+		if node, err := chain.getBlockNode(hash); err == nil {
+			if node.Status.KnownInvalid() {
+				return ruleError(ErrInvalidAncestor, fmt.Sprintf("header of invalid block %x", hash))
+			}
+
+			continue
+		}
+
+		err := CheckBlockHeaderSanity(header, chain.Params)
+		if err != nil {
+			return err
+		}
+
+		parent, err := chain.getBlockNode(header.PrevHash)
+		if err != nil {
+			return ErrUnconnectedHeaders
+		}
+
+		err = chain.checkHeaderContext(header, hash, parent)
+		if err != nil {
+			return err
+		}
+
+		node := newBlockNode(header, hash, parent)
+		node.Status |= statusHeaderOnly
+
+		err = chain.Database.Update(func(txn *badger.Txn) error {
+			return putBlockNode(txn, node)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+// heightIndexPrefix starts the key mapping a height of the active chain to
+// the hash of its block, followed by the height as a big endian uint32.
+var heightIndexPrefix = []byte("hi-")
+
+func heightIndexKey(height int) []byte {
+	key := append([]byte{}, heightIndexPrefix...)
+
+	return binary.BigEndian.AppendUint32(key, uint32(height))
+}
+
+// buildHeightIndex indexes the active chain by height when its tip is not
+// indexed yet, as in databases created before the index existed.
+func (chain *BlockChain) buildHeightIndex() error {
+	tip, err := chain.getBlockNode(chain.Tip())
+	if err != nil {
+		return err
+	}
+
+	hash, err := chain.GetBlockHash(tip.Height)
+	if err == nil && bytes.Equal(hash, tip.Hash) {
+		return nil
+	}
+
+	wb := chain.Database.NewWriteBatch()
+	defer wb.Cancel()
+
+	node := tip
+	for {
+		err = wb.Set(heightIndexKey(node.Height), node.Hash)
+		if err != nil {
+			return err
+		}
+
+		if len(node.PrevHash) == 0 {
+			break
+		}
+
+		node, err = chain.getBlockNode(node.PrevHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return wb.Flush()
+}
+
+// GetBlockHash returns the hash of the block at the given height of the
+// active chain.
+func (chain *BlockChain) GetBlockHash(height int) ([]byte, error) {
+	var hash []byte
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(heightIndexKey(height))
+		if err != nil {
+			return fmt.Errorf("no block at height %d", height)
+		}
+
+		hash, err = item.ValueCopy(nil)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+// GetBlockByHeight returns the block at the given height of the active
+// chain.
+func (chain *BlockChain) GetBlockByHeight(height int) (*Block, error) {
+	hash, err := chain.GetBlockHash(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return chain.GetBlock(hash)
+}
+
+// GetBlockHashes returns the hashes of the blocks of the active chain from
+// height from to height to, both included, in ascending height order. The
+// range is clamped to the blocks of the active chain.
+func (chain *BlockChain) GetBlockHashes(from, to int) [][]byte {
+	var hashes [][]byte
+
+	if from < 0 {
+		from = 0
+	}
+
+	if to < from {
+		return nil
+	}
+
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		last := heightIndexKey(to)
+		for it.Seek(heightIndexKey(from)); it.ValidForPrefix(heightIndexPrefix); it.Next() {
+			item := it.Item()
+			if bytes.Compare(item.Key(), last) > 0 {
+				break
+			}
+
+			hash, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+
+	return hashes
+}
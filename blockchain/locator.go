@@ -0,0 +1,114 @@
+package blockchain
+
+import "bytes"
+
+// locatorDenseHashes is the number of blocks listed one by one at the start
+// of a block locator, before the steps between them start doubling.
+const locatorDenseHashes = 10
+
+// BlockLocator returns the hashes a peer needs to find where its chain forks
+// from the branch ending at the given block: the last blocks one by one, then
+// exponentially fewer back to the genesis block, which is always the last.
+func (chain *BlockChain) BlockLocator(hash []byte) ([][]byte, error) {
+	node, err := chain.getBlockNode(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var locator [][]byte
+	step := 1
+
+	for {
+		locator = append(locator, node.Hash)
+		if node.Height == 0 {
+			break
+		}
+
+		if len(locator) >= locatorDenseHashes {
+			step *= 2
+		}
+
+		height := node.Height - step
+		if height < 0 {
+			height = 0
+		}
+
+		// once on the active chain the height index saves walking back
+		// block by block
+		if chain.onActiveChain(node) {
+			hash, err := chain.GetBlockHash(height)
+			if err != nil {
+				return nil, err
+			}
+			node, err = chain.getBlockNode(hash)
+		} else {
+			node, err = chain.ancestor(node, height)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return locator, nil
+}
+
+// LocateBlocks returns the hashes of the blocks of the active chain after the
+// fork point of the given locator, in ascending height order. It stops after
+// the block with stopHash, when given, or after max hashes.
+func (chain *BlockChain) LocateBlocks(locator [][]byte, stopHash []byte, max int) [][]byte {
+	from := chain.locateFork(locator) + 1
+	hashes := chain.GetBlockHashes(from, from+max-1)
+
+	for i, hash := range hashes {
+		if bytes.Equal(hash, stopHash) {
+			return hashes[:i+1]
+		}
+	}
+
+	return hashes
+}
+
+// LocateHeaders is like LocateBlocks but returns the headers of the blocks.
+func (chain *BlockChain) LocateHeaders(locator [][]byte, stopHash []byte, max int) []BlockHeader {
+	var headers []BlockHeader
+
+	for _, hash := range chain.LocateBlocks(locator, stopHash, max) {
+		node, err := chain.getBlockNode(hash)
+		if err != nil {
+			break
+		}
+
+		headers = append(headers, node.BlockHeader)
+	}
+
+	return headers
+}
+
+// locateFork returns the height of the first block of the locator that is
+// on the active chain. Both sides share at least the genesis block, so it
+// falls back to zero.
+func (chain *BlockChain) locateFork(locator [][]byte) int {
+	for _, hash := range locator {
+		node, err := chain.getBlockNode(hash)
+		if err != nil {
+			continue
+		}
+
+		if chain.onActiveChain(node) {
+			return node.Height
+		}
+	}
+
+	return 0
+}
+
+// onActiveChain reports whether the block of node is part of the active
+// chain.
+func (chain *BlockChain) onActiveChain(node *blockNode) bool {
+	hash, err := chain.GetBlockHash(node.Height)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(hash, node.Hash)
+}
@@ -1,60 +1,144 @@
-package blockchain
-
-import "crypto/sha256"
-
-type MerkleTree struct {
-	RootNode *MerkleNode
-}
-
-type MerkleNode struct {
-	Left  *MerkleNode
-	Right *MerkleNode
-	Data  []byte
-}
-
-func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []*MerkleNode
-
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
-	}
-
-	for _, dat := range data {
-		node := NewMerkleNode(nil, nil, dat)
-		nodes = append(nodes, node)
-	}
-
-	for i := 0; i < len(data)/2; i++ {
-		var level []*MerkleNode
-
-		for j := 0; j < len(nodes); j += 2 {
-			node := NewMerkleNode(nodes[j], nodes[j+1], nil)
-			level = append(level, node)
-		}
-
-		nodes = level
-	}
-
-	tree := &MerkleTree{
-		RootNode: nodes[0],
-	}
-
-	return tree
-}
-
-func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
-	node := MerkleNode{}
-
-	var hash [32]byte
-	if left == nil && right == nil {
-		hash = sha256.Sum256(data)
-	} else {
-		prevHashes := append(left.Data, right.Data...)
-		hash = sha256.Sum256(prevHashes)
-	}
-	node.Data = hash[:]
-	node.Left = left
-	node.Right = right
-
-	return &node
-}
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+type MerkleTree struct {
+	RootNode *MerkleNode
+}
+
+// MerkleProof proves that a leaf belongs to a merkle tree with the hashes
+// needed to recompute the root from it, from the leaf level up.
+type MerkleProof struct {
+	Index    int
+	Siblings []MerkleSibling
+}
+
+// MerkleSibling is the hash paired with the current node on the way up.
+type MerkleSibling struct {
+	Hash []byte
+
+	// Left tells whether the sibling is on the left of the current node.
+	Left bool
+}
+
+type MerkleNode struct {
+	Left  *MerkleNode
+	Right *MerkleNode
+	Data  []byte
+}
+
+// NewMerkleTree builds the tree whose leaves hash the given data. Levels
+// with an odd number of nodes pair their last node with itself.
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var nodes []*MerkleNode
+
+	if len(data)%2 != 0 {
+		data = append(data, data[len(data)-1])
+	}
+
+	for _, dat := range data {
+		node := NewMerkleNode(nil, nil, dat)
+		nodes = append(nodes, node)
+	}
+
+	for len(nodes) > 1 {
+		var level []*MerkleNode
+
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
+		for j := 0; j < len(nodes); j += 2 {
+			node := NewMerkleNode(nodes[j], nodes[j+1], nil)
+			level = append(level, node)
+		}
+
+		nodes = level
+	}
+
+	tree := &MerkleTree{
+		RootNode: nodes[0],
+	}
+
+	return tree
+}
+
+func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
+	node := MerkleNode{}
+
+	var hash [32]byte
+	if left == nil && right == nil {
+		hash = sha256.Sum256(data)
+	} else {
+		prevHashes := append(left.Data, right.Data...)
+		hash = sha256.Sum256(prevHashes)
+	}
+	node.Data = hash[:]
+	node.Left = left
+	node.Right = right
+
+	return &node
+}
+
+// Proof returns the inclusion proof of the leaf at the given index.
+func (t *MerkleTree) Proof(index int) (*MerkleProof, error) {
+	depth := 0
+	for node := t.RootNode; node.Left != nil; node = node.Left {
+		depth++
+	}
+
+	if index < 0 || index >= 1<<depth {
+		return nil, fmt.Errorf("leaf %d is out of the tree", index)
+	}
+
+	siblings := make([]MerkleSibling, depth)
+	node := t.RootNode
+
+	// the bits of the index, from the most significant, lead from the
+	// root to the leaf
+	for level := depth - 1; level >= 0; level-- {
+		if index>>level&1 == 0 {
+			siblings[level] = MerkleSibling{Hash: node.Right.Data, Left: false}
+			node = node.Left
+		} else {
+			siblings[level] = MerkleSibling{Hash: node.Left.Data, Left: true}
+			node = node.Right
+		}
+	}
+
+	return &MerkleProof{Index: index, Siblings: siblings}, nil
+}
+
+// VerifyMerkleProof reports whether proof links the leaf holding data to the
+// given merkle root. The sides of the siblings must agree with the index of
+// the leaf, so a proof cannot place the leaf anywhere else in the tree.
+func VerifyMerkleProof(data []byte, proof *MerkleProof, root []byte) bool {
+	if proof.Index < 0 || proof.Index>>len(proof.Siblings) != 0 {
+		return false
+	}
+
+	hash := sha256.Sum256(data)
+	current := hash[:]
+
+	for level, sibling := range proof.Siblings {
+		if sibling.Left != (proof.Index>>level&1 == 1) {
+			return false
+		}
+
+		var pair []byte
+		if sibling.Left {
+			pair = append(append(pair, sibling.Hash...), current...)
+		} else {
+			pair = append(append(pair, current...), sibling.Hash...)
+		}
+
+		hash = sha256.Sum256(pair)
+		current = hash[:]
+	}
+
+	return bytes.Equal(current, root)
+}
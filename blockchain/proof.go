@@ -1,86 +1,109 @@
-package blockchain
-
-import (
-	"bytes"
-	"crypto/sha256"
-	"encoding/binary"
-	"fmt"
-	"log"
-	"math"
-	"math/big"
-)
-
-const Difficulty = 14
-
-type ProofOfWork struct {
-	Block  Block
-	Target *big.Int
-}
-
-func NewProof(b Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-Difficulty))
-
-	pow := &ProofOfWork{b, target}
-
-	return pow
-}
-
-func (pow *ProofOfWork) InitData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(),
-			ToHex(int64(nonce)),
-			ToHex(int64(Difficulty)),
-		},
-		[]byte{},
-	)
-
-	return data
-}
-
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var intHash big.Int
-	var hash [32]byte
-
-	nonce := 0
-
-	for nonce < math.MaxInt64 {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
-
-		fmt.Printf("\r%x", hash)
-		intHash.SetBytes(hash[:])
-
-		if intHash.Cmp(pow.Target) == -1 {
-			break
-		} else {
-			nonce++
-		}
-	}
-	fmt.Println()
-
-	return nonce, hash[:]
-}
-
-func (pow *ProofOfWork) Validate() bool {
-	var intHash big.Int
-
-	data := pow.InitData(pow.Block.Nonce)
-
-	hash := sha256.Sum256(data)
-	intHash.SetBytes(hash[:])
-
-	return intHash.Cmp(pow.Target) == -1
-}
-
-func ToHex(num int64) []byte {
-	buff := new(bytes.Buffer)
-	err := binary.Write(buff, binary.BigEndian, num)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return buff.Bytes()
-}
+package blockchain
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"math/big"
+	"sync/atomic"
+)
+
+// cancelCheckInterval is the number of hashes tried between two checks of
+// whether the search was cancelled.
+const cancelCheckInterval = 1 << 12
+
+type ProofOfWork struct {
+	Header BlockHeader
+	Target *big.Int
+}
+
+func NewProof(h BlockHeader) *ProofOfWork {
+	target := CompactToBig(h.Bits)
+
+	pow := &ProofOfWork{h, target}
+
+	return pow
+}
+
+// InitData returns the serialized header with the given nonce, which is the
+// data hashed by the proof of work.
+func (pow *ProofOfWork) InitData(nonce uint32) []byte {
+	header := pow.Header
+	header.Nonce = nonce
+
+	return header.Serialize()
+}
+
+// Run searches the whole nonce space for a hash below the target and returns
+// the nonce found with its hash, and whether one was found at all. When none
+// is found the last nonce tried is returned.
+func (pow *ProofOfWork) Run() (uint32, []byte, bool) {
+	nonce, hash, found := pow.Search(context.Background(), 0, math.MaxUint32, nil)
+	fmt.Printf("%x\n", hash)
+
+	return nonce, hash, found
+}
+
+// Search tries the nonces from start to end, both included, until one gives
+// a hash below the target or ctx is cancelled. It returns the last nonce
+// tried with its hash and whether it meets the target. The number of hashes
+// computed is added to hashes, when given, as the search goes.
+func (pow *ProofOfWork) Search(ctx context.Context, start, end uint32, hashes *atomic.Uint64) (uint32, []byte, bool) {
+	var intHash big.Int
+	var hash [32]byte
+
+	header := pow.Header
+	tried := uint64(0)
+
+	for nonce := start; ; nonce++ {
+		header.Nonce = nonce
+		hash = sha256.Sum256(header.Serialize())
+		tried++
+
+		intHash.SetBytes(hash[:])
+		if intHash.Cmp(pow.Target) == -1 {
+			addHashes(hashes, tried)
+			return nonce, hash[:], true
+		}
+
+		if nonce == end {
+			addHashes(hashes, tried)
+			return nonce, hash[:], false
+		}
+
+		if tried%cancelCheckInterval == 0 {
+			addHashes(hashes, tried)
+			tried = 0
+
+			if ctx.Err() != nil {
+				return nonce, hash[:], false
+			}
+		}
+	}
+}
+
+func addHashes(hashes *atomic.Uint64, n uint64) {
+	if hashes != nil {
+		hashes.Add(n)
+	}
+}
+
+// Hash returns the proof of work hash of the header for its own nonce.
+func (pow *ProofOfWork) Hash() []byte {
+	hash := sha256.Sum256(pow.InitData(pow.Header.Nonce))
+
+	return hash[:]
+}
+
+func (pow *ProofOfWork) Validate() bool {
+	var intHash big.Int
+
+	if pow.Target.Sign() <= 0 {
+		return false
+	}
+
+	intHash.SetBytes(pow.Hash())
+
+	return intHash.Cmp(pow.Target) == -1
+}
@@ -0,0 +1,73 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+)
+
+// TxProof lets a client that only keeps headers check that a transaction
+// was included in a block.
+type TxProof struct {
+	Header    BlockHeader
+	BlockHash []byte
+	TxID      []byte
+	Proof     MerkleProof
+}
+
+// GetMerkleProof returns the inclusion proof of the transaction with the
+// given ID in the block of the active chain that holds it.
+func (chain *BlockChain) GetMerkleProof(txID []byte) (*TxProof, error) {
+	_, blockHash, err := chain.LocateTransaction(txID)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := chain.GetBlock(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	position := -1
+	for i, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			position = i
+			break
+		}
+	}
+	if position < 0 {
+		return nil, ErrTxNotFound
+	}
+
+	proof, err := block.merkleTree().Proof(position)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxProof{
+		Header:    block.BlockHeader,
+		BlockHash: block.Hash,
+		TxID:      txID,
+		Proof:     *proof,
+	}, nil
+}
+
+// Verify checks the proof on its own: the header must hash to the block hash
+// and meet its target, and the merkle path must lead from the transaction, at
+// the index of the proof, to the merkle root of the header. It does not tell
+// whether the block belongs to the best chain, which the caller has to check
+// against its headers.
+func (p *TxProof) Verify() error {
+	if !bytes.Equal(p.Header.BlockHash(), p.BlockHash) {
+		return errors.New("header does not match the block hash")
+	}
+
+	if !NewProof(p.Header).Validate() {
+		return errors.New("header does not meet its proof of work target")
+	}
+
+	if !VerifyMerkleProof(p.TxID, &p.Proof, p.Header.MerkleRoot) {
+		return errors.New("merkle path does not lead to the merkle root")
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package blockchain
+
+import "github.com/dev-rodrigobaliza/go-blockchain/params"
+
+// CalcBlockSubsidy returns the amount the coinbase of the block at the given
+// height may mint on top of the fees. The subsidy starts at the coinbase
+// reward of the chain and halves every halving interval, and it stops once
+// the maximum supply has been minted.
+func CalcBlockSubsidy(height int, chainParams *params.ChainParams) int {
+	return issuedBefore(height+1, chainParams) - issuedBefore(height, chainParams)
+}
+
+// issuedBefore returns the amount minted by the blocks below height.
+func issuedBefore(height int, chainParams *params.ChainParams) int {
+	interval := chainParams.SubsidyHalvingInterval
+	reward := chainParams.CoinbaseReward
+
+	total := 0
+	if interval <= 0 {
+		total = height * reward
+	} else {
+		for era := 0; era < 63 && reward>>era > 0 && height > era*interval; era++ {
+			blocks := height - era*interval
+			if blocks > interval {
+				blocks = interval
+			}
+			total += blocks * (reward >> era)
+		}
+	}
+
+	if total > chainParams.MaxSupply {
+		return chainParams.MaxSupply
+	}
+
+	return total
+}
@@ -1,232 +1,360 @@
-package blockchain
-
-import (
-	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/gob"
-	"encoding/hex"
-	"fmt"
-	"log"
-	"math/big"
-	"strings"
-
-	"github.com/dev-rodrigobaliza/go-blockchain/crypto"
-	"github.com/dev-rodrigobaliza/go-blockchain/utils"
-	wal "github.com/dev-rodrigobaliza/go-blockchain/wallet"
-	"github.com/goccy/go-json"
-)
-
-type Transaction struct {
-	ID      []byte     `json:"id,omitempty"`
-	Inputs  []TxInput  `json:"tx_input,omitempty"`
-	Outputs []TxOutput `json:"tx_output,omitempty"`
-}
-
-func NewTransaction(wallet *wal.Wallet, to string, amount int, UTXO *UTXOSet) Transaction {
-	var inputs []TxInput
-	var outputs []TxOutput
-
-	pubKeyHash := crypto.PublicKeyHash(wallet.PublicKey)
-
-	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount)
-	if acc < amount {
-		log.Panic("Error: not enough funds")
-	}
-
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		utils.Handle(err)
-
-		for _, out := range outs {
-			input := NewTxInput(txID, out, nil, wallet.PublicKey)
-			inputs = append(inputs, input)
-		}
-	}
-
-	from := string(wallet.Address())
-	outputs = append(outputs, *NewTxOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTxOutput(acc-amount, from))
-	}
-
-	tx := Transaction{nil, inputs, outputs}
-	tx.ID = tx.Hash()
-	UTXO.Blockchain.SignTransaction(tx, *wallet.GetPrivateKey())
-
-	return tx
-}
-
-func (tx *Transaction) Serialize() []byte {
-	buffer, err := json.Marshal(tx)
-	utils.Handle(err)
-
-	return buffer
-}
-
-func (tx *Transaction) Deserialize(buffer []byte) error {
-	err := json.Unmarshal(buffer, tx)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (tx *Transaction) Hash() []byte {
-	var hash [32]byte
-
-	txCopy := *tx
-	tx.ID = []byte{}
-	hash = sha256.Sum256(txCopy.Serialize())
-
-	return hash[:]
-}
-
-func (tx *Transaction) SetID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
-
-	encode := gob.NewEncoder(&encoded)
-	err := encode.Encode(tx)
-	utils.Handle(err)
-
-	hash = sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
-}
-
-func (tx *Transaction) IsCoinbase() bool {
-	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
-}
-
-func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey, prevTXs map[string]Transaction) {
-	if tx.IsCoinbase() {
-		return
-	}
-
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("Error: previous transaction is not correct")
-		}
-	}
-
-	txCopy := tx.TrimmedCopy()
-
-	for inId, in := range txCopy.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
-
-		r, s, err := ecdsa.Sign(rand.Reader, privKey, txCopy.ID)
-		utils.Handle(err)
-		signature := append(r.Bytes(), s.Bytes()...)
-
-		tx.Inputs[inId].Signature = signature
-	}
-}
-
-func (tx Transaction) String() string {
-	var lines []string
-
-	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
-	for i, input := range tx.Inputs {
-
-		lines = append(lines, fmt.Sprintf("     Input %d:", i))
-		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.ID))
-		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
-	}
-
-	for i, output := range tx.Outputs {
-		lines = append(lines, fmt.Sprintf("     Output %d:", i))
-		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
-	}
-
-	return strings.Join(lines, "\n")
-}
-
-func (tx *Transaction) TrimmedCopy() Transaction {
-	var inputs []TxInput
-	var outputs []TxOutput
-
-	for _, input := range tx.Inputs {
-		inputs = append(inputs, NewTxInput(input.ID, input.Out, nil, nil))
-	}
-
-	for _, output := range tx.Outputs {
-		outputs = append(outputs, TxOutput{output.Value, output.PubKeyHash})
-	}
-
-	txCopy := Transaction{tx.ID, inputs, outputs}
-
-	return txCopy
-}
-
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
-	if tx.IsCoinbase() {
-		return true
-	}
-
-	for _, input := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(input.ID)].ID == nil {
-			log.Panic("Previous transaction is not correct")
-		}
-	}
-
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
-	for inID, input := range tx.Inputs {
-		prevTx := prevTXs[hex.EncodeToString(input.ID)]
-		txCopy.Inputs[inID].Signature = nil
-		txCopy.Inputs[inID].PubKey = prevTx.Outputs[input.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inID].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(input.Signature)
-		r.SetBytes(input.Signature[:(sigLen / 2)])
-		s.SetBytes(input.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(input.PubKey)
-		x.SetBytes(input.PubKey[:(keyLen / 2)])
-		y.SetBytes(input.PubKey[(keyLen / 2):])
-
-		rawPubKey := &ecdsa.PublicKey{
-			Curve: curve,
-			X:     &x,
-			Y:     &y,
-		}
-		if !ecdsa.Verify(rawPubKey, txCopy.ID, &r, &s) {
-			return false
-		}
-	}
-
-	return true
-}
-
-func CoinbaseTx(to, data string) Transaction {
-	if data == "" {
-		randData := make([]byte, 24)
-		_, err := rand.Read(randData)
-		utils.Handle(err)
-		data = fmt.Sprintf("%x", randData)
-	}
-
-	txIn := NewTxInput([]byte{}, -1, nil, []byte(data))
-	txOut := NewTxOutput(20, to)
-
-	tx := Transaction{nil, []TxInput{txIn}, []TxOutput{*txOut}}
-	tx.SetID()
-
-	return tx
-}
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/crypto"
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	wal "github.com/dev-rodrigobaliza/go-blockchain/wallet"
+	"github.com/goccy/go-json"
+)
+
+type Transaction struct {
+	ID      []byte     `json:"id,omitempty"`
+	Inputs  []TxInput  `json:"tx_input,omitempty"`
+	Outputs []TxOutput `json:"tx_output,omitempty"`
+}
+
+// NewTransaction creates a signed transaction paying amount to the given
+// address. The inputs cover the amount plus the fee, which is left to the
+// miner, and whatever remains goes back to the wallet as change. A
+// replaceable transaction signals that it may be replaced by one paying a
+// higher fee while unconfirmed.
+func NewTransaction(wallet *wal.Wallet, to string, amount, fee int, replaceable bool, UTXO *UTXOSet) Transaction {
+	var inputs []TxInput
+	var outputs []TxOutput
+
+	if fee < 0 {
+		log.Panic("Error: fee cannot be negative")
+	}
+
+	pubKeyHash := crypto.PublicKeyHash(wallet.PublicKey)
+
+	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount+fee)
+	if acc < amount+fee {
+		log.Panic("Error: not enough funds")
+	}
+
+	for txid, outs := range validOutputs {
+		txID, err := hex.DecodeString(txid)
+		utils.Handle(err)
+
+		for _, out := range outs {
+			input := NewTxInput(txID, out, nil, wallet.PublicKey)
+			if replaceable {
+				input.Sequence = MaxRBFSequence
+			}
+			inputs = append(inputs, input)
+		}
+	}
+
+	from := string(wallet.Address(UTXO.Blockchain.Params))
+	outputs = append(outputs, *NewTxOutput(amount, to))
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTxOutput(acc-amount-fee, from))
+	}
+
+	tx := Transaction{nil, inputs, outputs}
+	UTXO.Blockchain.SignTransaction(tx, *wallet.GetPrivateKey())
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+// NewTransactionFeeRate creates a transaction like NewTransaction, with the
+// fee set from a rate per 1000 bytes of the serialized transaction. The fee
+// changes the size, so the transaction is rebuilt until the fee covers it.
+func NewTransactionFeeRate(wallet *wal.Wallet, to string, amount, feeRate int, replaceable bool, UTXO *UTXOSet) Transaction {
+	fee := 0
+
+	for {
+		tx := NewTransaction(wallet, to, amount, fee, replaceable, UTXO)
+
+		required := CalcFee(tx.Size(), feeRate)
+		if fee >= required {
+			return tx
+		}
+
+		fee = required
+	}
+}
+
+// BumpFee rebuilds a replaceable transaction of the wallet so it pays newFee
+// instead of oldFee. The difference is taken from the change output, which
+// is dropped when nothing is left in it, and the inputs are signed again.
+func BumpFee(wallet *wal.Wallet, tx *Transaction, oldFee, newFee int, UTXO *UTXOSet) (Transaction, error) {
+	if !tx.SignalsReplacement() {
+		return Transaction{}, fmt.Errorf("transaction %x does not signal replacement", tx.ID)
+	}
+
+	if newFee <= oldFee {
+		return Transaction{}, fmt.Errorf("new fee %d must be higher than the current fee %d", newFee, oldFee)
+	}
+
+	pubKeyHash := crypto.PublicKeyHash(wallet.PublicKey)
+
+	var inputs []TxInput
+	for _, in := range tx.Inputs {
+		if !in.UsesKey(pubKeyHash) {
+			return Transaction{}, fmt.Errorf("transaction %x spends outputs of another wallet", tx.ID)
+		}
+
+		input := NewTxInput(in.ID, in.Out, nil, in.PubKey)
+		input.Sequence = in.Sequence
+		inputs = append(inputs, input)
+	}
+
+	change := -1
+	for i, out := range tx.Outputs {
+		if out.IsLockedWithKey(pubKeyHash) {
+			change = i
+		}
+	}
+
+	if change < 0 {
+		return Transaction{}, fmt.Errorf("transaction %x has no change output to take the fee from", tx.ID)
+	}
+
+	changeValue := tx.Outputs[change].Value - (newFee - oldFee)
+	if changeValue < 0 {
+		return Transaction{}, fmt.Errorf("change of %d cannot cover a fee increase of %d", tx.Outputs[change].Value, newFee-oldFee)
+	}
+
+	var outputs []TxOutput
+	for i, out := range tx.Outputs {
+		if i == change {
+			if changeValue == 0 {
+				continue
+			}
+			out.Value = changeValue
+		}
+		outputs = append(outputs, out)
+	}
+
+	if len(outputs) == 0 {
+		return Transaction{}, fmt.Errorf("transaction %x would be left without outputs", tx.ID)
+	}
+
+	bumped := Transaction{nil, inputs, outputs}
+	UTXO.Blockchain.SignTransaction(bumped, *wallet.GetPrivateKey())
+	bumped.ID = bumped.Hash()
+
+	return bumped, nil
+}
+
+// SignalsReplacement reports whether any input of the transaction opts in to
+// being replaced while unconfirmed.
+func (tx *Transaction) SignalsReplacement() bool {
+	for _, in := range tx.Inputs {
+		if in.Sequence <= MaxRBFSequence {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CalcFee returns the fee a transaction of the given size pays at feeRate,
+// expressed per 1000 bytes and rounded up.
+func CalcFee(size, feeRate int) int {
+	return (size*feeRate + 999) / 1000
+}
+
+// FeeRate returns the fee per 1000 bytes paid by a transaction of the given
+// size.
+func FeeRate(fee, size int) int {
+	if size == 0 {
+		return 0
+	}
+
+	return fee * 1000 / size
+}
+
+// Size returns the length of the serialized transaction.
+func (tx *Transaction) Size() int {
+	return len(tx.Serialize())
+}
+
+func (tx *Transaction) Serialize() []byte {
+	buffer, err := json.Marshal(tx)
+	utils.Handle(err)
+
+	return buffer
+}
+
+func (tx *Transaction) Deserialize(buffer []byte) error {
+	err := json.Unmarshal(buffer, tx)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (tx *Transaction) Hash() []byte {
+	var hash [32]byte
+
+	txCopy := *tx
+	txCopy.ID = []byte{}
+	hash = sha256.Sum256(txCopy.Serialize())
+
+	return hash[:]
+}
+
+func (tx *Transaction) IsCoinbase() bool {
+	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
+}
+
+func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+	if tx.IsCoinbase() {
+		return
+	}
+
+	for _, in := range tx.Inputs {
+		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
+			log.Panic("Error: previous transaction is not correct")
+		}
+	}
+
+	txCopy := tx.TrimmedCopy()
+
+	for inId, in := range txCopy.Inputs {
+		prevTX := prevTXs[hex.EncodeToString(in.ID)]
+		txCopy.Inputs[inId].Signature = nil
+		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
+		txCopy.ID = txCopy.Hash()
+		txCopy.Inputs[inId].PubKey = nil
+
+		r, s, err := ecdsa.Sign(rand.Reader, privKey, txCopy.ID)
+		utils.Handle(err)
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+
+		tx.Inputs[inId].Signature = signature
+	}
+}
+
+func (tx Transaction) String() string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	for i, input := range tx.Inputs {
+
+		lines = append(lines, fmt.Sprintf("     Input %d:", i))
+		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.ID))
+		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
+		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
+		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		lines = append(lines, fmt.Sprintf("       Sequence:  %08x", input.Sequence))
+	}
+
+	for i, output := range tx.Outputs {
+		lines = append(lines, fmt.Sprintf("     Output %d:", i))
+		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
+		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (tx *Transaction) TrimmedCopy() Transaction {
+	var inputs []TxInput
+	var outputs []TxOutput
+
+	for _, input := range tx.Inputs {
+		trimmed := NewTxInput(input.ID, input.Out, nil, nil)
+		trimmed.Sequence = input.Sequence
+		inputs = append(inputs, trimmed)
+	}
+
+	for _, output := range tx.Outputs {
+		outputs = append(outputs, TxOutput{output.Value, output.PubKeyHash})
+	}
+
+	txCopy := Transaction{tx.ID, inputs, outputs}
+
+	return txCopy
+}
+
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+
+	for _, input := range tx.Inputs {
+		prevTx := prevTXs[hex.EncodeToString(input.ID)]
+		if prevTx.ID == nil || input.Out < 0 || input.Out >= len(prevTx.Outputs) {
+			return false
+		}
+
+		// the signature only proves the input key signed, so the key must
+		// also be the one the output is locked to
+		if !input.UsesKey(prevTx.Outputs[input.Out].PubKeyHash) {
+			return false
+		}
+	}
+
+	txCopy := tx.TrimmedCopy()
+	curve := elliptic.P256()
+
+	for inID, input := range tx.Inputs {
+		prevTx := prevTXs[hex.EncodeToString(input.ID)]
+		txCopy.Inputs[inID].Signature = nil
+		txCopy.Inputs[inID].PubKey = prevTx.Outputs[input.Out].PubKeyHash
+		txCopy.ID = txCopy.Hash()
+		txCopy.Inputs[inID].PubKey = nil
+
+		r := big.Int{}
+		s := big.Int{}
+		sigLen := len(input.Signature)
+		keyLen := len(input.PubKey)
+		if sigLen == 0 || keyLen == 0 {
+			return false
+		}
+		r.SetBytes(input.Signature[:(sigLen / 2)])
+		s.SetBytes(input.Signature[(sigLen / 2):])
+
+		x := big.Int{}
+		y := big.Int{}
+		x.SetBytes(input.PubKey[:(keyLen / 2)])
+		y.SetBytes(input.PubKey[(keyLen / 2):])
+
+		rawPubKey := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     &x,
+			Y:     &y,
+		}
+		if !ecdsa.Verify(rawPubKey, txCopy.ID, &r, &s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CoinbaseTx creates the transaction minting value to the given address.
+func CoinbaseTx(to, data string, value int) Transaction {
+	if data == "" {
+		randData := make([]byte, 24)
+		_, err := rand.Read(randData)
+		utils.Handle(err)
+		data = fmt.Sprintf("%x", randData)
+	}
+
+	txIn := NewTxInput([]byte{}, -1, nil, []byte(data))
+	txOut := NewTxOutput(value, to)
+
+	tx := Transaction{nil, []TxInput{txIn}, []TxOutput{*txOut}}
+	tx.ID = tx.Hash()
+
+	return tx
+}
@@ -1,24 +1,35 @@
-package blockchain
-
-import (
-	"bytes"
-
-	"github.com/dev-rodrigobaliza/go-blockchain/crypto"
-)
-
-type TxInput struct {
-	ID        []byte `json:"id,omitempty"`
-	Out       int
-	Signature []byte `json:"signature,omitempty"`
-	PubKey    []byte `json:"pub_key,omitempty"`
-}
-
-func NewTxInput(id []byte, out int, signature []byte, pubKey []byte) TxInput {
-	return TxInput{id, out, signature, pubKey}
-}
-
-func (in TxInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := crypto.PublicKeyHash(in.PubKey)
-
-	return bytes.Equal(lockingHash, pubKeyHash)
-}
+package blockchain
+
+import (
+	"bytes"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/crypto"
+)
+
+const (
+	// MaxTxInSequenceNum is the sequence of an input that does not signal
+	// anything.
+	MaxTxInSequenceNum uint32 = 0xffffffff
+
+	// MaxRBFSequence is the highest sequence of an input signaling that its
+	// transaction may be replaced while unconfirmed.
+	MaxRBFSequence uint32 = 0xfffffffd
+)
+
+type TxInput struct {
+	ID        []byte `json:"id,omitempty"`
+	Out       int
+	Signature []byte `json:"signature,omitempty"`
+	PubKey    []byte `json:"pub_key,omitempty"`
+	Sequence  uint32
+}
+
+func NewTxInput(id []byte, out int, signature []byte, pubKey []byte) TxInput {
+	return TxInput{id, out, signature, pubKey, MaxTxInSequenceNum}
+}
+
+func (in TxInput) UsesKey(pubKeyHash []byte) bool {
+	lockingHash := crypto.PublicKeyHash(in.PubKey)
+
+	return bytes.Equal(lockingHash, pubKeyHash)
+}
@@ -0,0 +1,208 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	"github.com/dgraph-io/badger"
+	"github.com/goccy/go-json"
+)
+
+var (
+	// txIndexPrefix starts the key locating a transaction of the active
+	// chain, followed by its ID.
+	txIndexPrefix = []byte("txi-")
+
+	// txIndexEnabledKey is set once the transaction index covers the whole
+	// active chain, from then on it is kept up to date.
+	txIndexEnabledKey = []byte("txindex")
+)
+
+// ErrTxNotFound is returned when a transaction is not in the active chain.
+var ErrTxNotFound = errors.New("Transaction does not exist")
+
+// txLocation is the entry of the transaction index.
+type txLocation struct {
+	BlockHash []byte `json:"block_hash"`
+	Position  int    `json:"position"`
+}
+
+func txIndexKey(txID []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), txID...)
+}
+
+func (l *txLocation) serialize() []byte {
+	buffer, err := json.Marshal(l)
+	utils.Handle(err)
+
+	return buffer
+}
+
+func (l *txLocation) deserialize(buffer []byte) error {
+	return json.Unmarshal(buffer, l)
+}
+
+// indexTransactions adds the transactions of a connected block to the
+// transaction index.
+func indexTransactions(txn *badger.Txn, block *Block) error {
+	for i, tx := range block.Transactions {
+		loc := txLocation{BlockHash: block.Hash, Position: i}
+
+		err := txn.Set(txIndexKey(tx.ID), loc.serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexTransactions removes the transactions of a disconnected block from
+// the transaction index.
+func unindexTransactions(txn *badger.Txn, block *Block) error {
+	for _, tx := range block.Transactions {
+		err := txn.Delete(txIndexKey(tx.ID))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HasTxIndex reports whether the transaction index is enabled.
+func (chain *BlockChain) HasTxIndex() bool {
+	return chain.txIndex
+}
+
+// loadIndexState reads which of the optional indexes are enabled.
+func (chain *BlockChain) loadIndexState() error {
+	return chain.Database.View(func(txn *badger.Txn) error {
+		for key, enabled := range map[string]*bool{
+			string(txIndexEnabledKey):   &chain.txIndex,
+			string(addrIndexEnabledKey): &chain.addrIndex,
+		} {
+			_, err := txn.Get([]byte(key))
+			if err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+
+			*enabled = err == nil
+		}
+
+		return nil
+	})
+}
+
+// BuildTxIndex indexes the transactions of the whole active chain and
+// enables the index, which is then kept up to date as blocks connect and
+// disconnect. It returns the number of transactions indexed.
+func (chain *BlockChain) BuildTxIndex() (int, error) {
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+
+	chain.txIndex = false
+	UTXOSet := UTXOSet{chain}
+	UTXOSet.DeleteByPrefix(txIndexPrefix)
+
+	err := chain.Database.Update(func(txn *badger.Txn) error {
+		return txn.Delete(txIndexEnabledKey)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	wb := chain.Database.NewWriteBatch()
+	defer wb.Cancel()
+
+	count := 0
+	for _, hash := range chain.GetBlockHashes(0, chain.GetBestHeight()) {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return 0, err
+		}
+
+		for i, tx := range block.Transactions {
+			loc := txLocation{BlockHash: block.Hash, Position: i}
+
+			err = wb.Set(txIndexKey(tx.ID), loc.serialize())
+			if err != nil {
+				return 0, err
+			}
+			count++
+		}
+	}
+
+	err = wb.Set(txIndexEnabledKey, []byte{1})
+	if err != nil {
+		return 0, err
+	}
+
+	err = wb.Flush()
+	if err != nil {
+		return 0, err
+	}
+
+	chain.txIndex = true
+
+	return count, nil
+}
+
+// LocateTransaction returns a transaction of the active chain along with the
+// hash of the block holding it. The transaction index is used when enabled,
+// otherwise the blocks are scanned from the tip.
+func (chain *BlockChain) LocateTransaction(ID []byte) (Transaction, []byte, error) {
+	if !chain.txIndex {
+		return chain.scanTransaction(ID)
+	}
+
+	var loc txLocation
+	err := chain.Database.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(txIndexKey(ID))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return loc.deserialize(val)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return Transaction{}, nil, ErrTxNotFound
+	}
+	if err != nil {
+		return Transaction{}, nil, err
+	}
+
+	block, err := chain.GetBlock(loc.BlockHash)
+	if err != nil {
+		return Transaction{}, nil, err
+	}
+
+	if loc.Position >= len(block.Transactions) || !bytes.Equal(block.Transactions[loc.Position].ID, ID) {
+		return Transaction{}, nil, errors.New("transaction index entry does not match its block")
+	}
+
+	return block.Transactions[loc.Position], block.Hash, nil
+}
+
+func (chain *BlockChain) scanTransaction(ID []byte) (Transaction, []byte, error) {
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, ID) {
+				return tx, block.Hash, nil
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return Transaction{}, nil, ErrTxNotFound
+}
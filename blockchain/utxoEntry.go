@@ -0,0 +1,34 @@
+package blockchain
+
+import (
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	"github.com/goccy/go-json"
+)
+
+// UtxoEntry is an unspent output along with where it was created. It is
+// what the UTXO set stores for every outpoint.
+type UtxoEntry struct {
+	Output TxOutput `json:"output"`
+
+	// Coinbase tells whether the output was created by a coinbase, and
+	// Height is the height of the block holding its transaction.
+	Coinbase bool `json:"coinbase,omitempty"`
+	Height   int  `json:"height"`
+}
+
+// IsMature reports whether the output may be spent by a transaction in a
+// block at the given height.
+func (e *UtxoEntry) IsMature(height, maturity int) bool {
+	return !e.Coinbase || height-e.Height >= maturity
+}
+
+func (e *UtxoEntry) serialize() []byte {
+	buffer, err := json.Marshal(e)
+	utils.Handle(err)
+
+	return buffer
+}
+
+func (e *UtxoEntry) deserialize(buffer []byte) error {
+	return json.Unmarshal(buffer, e)
+}
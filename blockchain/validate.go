@@ -0,0 +1,394 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+)
+
+// maxTimeOffset is how far in the future a block timestamp may be.
+const maxTimeOffset = 2 * time.Hour
+
+// CheckBlockHeaderSanity runs the checks that need nothing but the header
+// and the chain params: the range of the target, the proof of work and the
+// timestamp.
+func CheckBlockHeaderSanity(header *BlockHeader, chainParams *params.ChainParams) error {
+	pow := NewProof(*header)
+	if pow.Target.Sign() <= 0 || pow.Target.Cmp(chainParams.PowLimit) > 0 {
+		return ruleError(ErrUnexpectedDifficulty, fmt.Sprintf("block target %064x is outside the allowed range", pow.Target))
+	}
+
+	if !pow.Validate() {
+		return ruleError(ErrHighHash, fmt.Sprintf("block %x does not meet its target", pow.Hash()))
+	}
+
+	maxTimestamp := time.Now().Add(maxTimeOffset).Unix()
+	if header.Timestamp > maxTimestamp {
+		return ruleError(ErrTimeTooNew, fmt.Sprintf("block timestamp %d is too far in the future", header.Timestamp))
+	}
+
+	return nil
+}
+
+// CheckBlockSanity runs the checks that need nothing but the block itself
+// and the chain params: the header, the transactions committed by it and
+// the coinbase layout.
+func CheckBlockSanity(block *Block, chainParams *params.ChainParams) error {
+	err := CheckBlockHeaderSanity(&block.BlockHeader, chainParams)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(block.BlockHash(), block.Hash) {
+		return ruleError(ErrBadBlockHash, fmt.Sprintf("block hash %x does not match its header", block.Hash))
+	}
+
+	if len(block.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, "block does not contain any transactions")
+	}
+
+	if !block.Transactions[0].IsCoinbase() {
+		return ruleError(ErrFirstTxNotCoinbase, "first transaction in block is not a coinbase")
+	}
+
+	if !bytes.Equal(block.HashTransactions(), block.MerkleRoot) {
+		return ruleError(ErrBadMerkleRoot, fmt.Sprintf("block merkle root %x does not match its transactions", block.MerkleRoot))
+	}
+
+	size := len(block.Serialize())
+	if size > chainParams.MaxBlockSize {
+		return ruleError(ErrBlockTooBig, fmt.Sprintf("block size %d is larger than the allowed %d", size, chainParams.MaxBlockSize))
+	}
+
+	sigOps := 0
+	for _, tx := range block.Transactions {
+		sigOps += CountSigOps(&tx)
+	}
+
+	if sigOps > chainParams.MaxBlockSigOps {
+		return ruleError(ErrTooManySigOps, fmt.Sprintf("block requires %d signature checks, more than the allowed %d", sigOps, chainParams.MaxBlockSigOps))
+	}
+
+	seen := make(map[string]bool)
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return ruleError(ErrMultipleCoinbases, fmt.Sprintf("block contains second coinbase at index %d", i))
+		}
+
+		err := CheckTransactionSanity(&tx, chainParams)
+		if err != nil {
+			return err
+		}
+
+		txID := hex.EncodeToString(tx.ID)
+		if seen[txID] {
+			return ruleError(ErrDuplicateTx, fmt.Sprintf("block contains duplicate transaction %s", txID))
+		}
+		seen[txID] = true
+	}
+
+	return nil
+}
+
+// CheckTransactionSanity runs the checks that need nothing but the
+// transaction itself and the limits of the chain params.
+func CheckTransactionSanity(tx *Transaction, chainParams *params.ChainParams) error {
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return ruleError(ErrBadTxID, fmt.Sprintf("transaction ID %x does not match its contents", tx.ID))
+	}
+
+	if len(tx.Inputs) == 0 {
+		return ruleError(ErrNoTxInputs, fmt.Sprintf("transaction %x has no inputs", tx.ID))
+	}
+
+	if len(tx.Outputs) == 0 {
+		return ruleError(ErrNoTxOutputs, fmt.Sprintf("transaction %x has no outputs", tx.ID))
+	}
+
+	// once the subsidy runs out a coinbase may have nothing to pay
+	totalOut := 0
+	for _, out := range tx.Outputs {
+		if out.Value < 0 || (out.Value == 0 && !tx.IsCoinbase()) {
+			return ruleError(ErrBadTxOutValue, fmt.Sprintf("transaction %x has an output of value %d", tx.ID, out.Value))
+		}
+
+		var ok bool
+		totalOut, ok = addValue(totalOut, out.Value, chainParams.MaxSupply)
+		if !ok {
+			return ruleError(ErrBadTxOutValue, fmt.Sprintf("transaction %x pays out more than the max supply %d", tx.ID, chainParams.MaxSupply))
+		}
+	}
+
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	spent := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		key := outpointKey(in.ID, in.Out)
+		if spent[key] {
+			return ruleError(ErrDoubleSpend, fmt.Sprintf("transaction %x spends %s twice", tx.ID, key))
+		}
+		spent[key] = true
+	}
+
+	return nil
+}
+
+// CountSigOps returns the number of signature checks needed to validate tx,
+// one per input of anything but a coinbase.
+func CountSigOps(tx *Transaction) int {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	return len(tx.Inputs)
+}
+
+// ValidateBlock runs the full validation pipeline for a block: the sanity
+// checks, the checks against its parent and, when the block extends the
+// active chain, the checks of its transactions against the UTXO set. AddBlock
+// runs it on every block, received from the network or mined, and the
+// transactions of side branch blocks are checked when a reorganization
+// connects them.
+func (chain *BlockChain) ValidateBlock(block *Block) error {
+	err := CheckBlockSanity(block, chain.Params)
+	if err != nil {
+		return err
+	}
+
+	// a parent only known by its header cannot be connected either
+	parent, err := chain.getBlockNode(block.PrevHash)
+	if err != nil || !parent.Status.HaveData() {
+		return ErrOrphanBlock
+	}
+
+	err = chain.checkHeaderContext(&block.BlockHeader, block.Hash, parent)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(block.PrevHash, chain.Tip()) {
+		return nil
+	}
+
+	return chain.checkConnectBlock(block)
+}
+
+// checkHeaderContext runs the checks that depend on the ancestors of the
+// block: its height, the difficulty it must use and its timestamp. They only
+// need the header, so they also run on headers received ahead of their
+// blocks.
+func (chain *BlockChain) checkHeaderContext(header *BlockHeader, hash []byte, parent *blockNode) error {
+	if parent.Status.KnownInvalid() {
+		return ruleError(ErrInvalidAncestor, fmt.Sprintf("block %x builds on invalid block %x", hash, parent.Hash))
+	}
+
+	if header.Height != parent.Height+1 {
+		return ruleError(ErrBadHeight, fmt.Sprintf("block height %d does not follow parent height %d", header.Height, parent.Height))
+	}
+
+	expectedBits, err := chain.calcNextRequiredBits(parent)
+	if err != nil {
+		return err
+	}
+
+	if header.Bits != expectedBits {
+		return ruleError(ErrUnexpectedDifficulty, fmt.Sprintf("block difficulty bits %08x are not the expected %08x", header.Bits, expectedBits))
+	}
+
+	medianTime, err := chain.medianTimePast(parent)
+	if err != nil {
+		return err
+	}
+
+	if header.Timestamp <= medianTime {
+		return ruleError(ErrTimeTooOld, fmt.Sprintf("block timestamp %d is not after the median time past %d", header.Timestamp, medianTime))
+	}
+
+	return nil
+}
+
+// checkConnectBlock validates the transactions of a block against the UTXO
+// set. It must only be called when the parent of the block is the tip of
+// the active chain.
+func (chain *BlockChain) checkConnectBlock(block *Block) error {
+	view := newUtxoView(&UTXOSet{chain})
+	pending := make(map[string]Transaction)
+	fees := 0
+
+	maxSupply := chain.Params.MaxSupply
+
+	for _, tx := range block.Transactions[1:] {
+		fee, err := chain.checkTransactionInputs(&tx, block.Height, view, pending)
+		if err != nil {
+			return err
+		}
+
+		var ok bool
+		fees, ok = addValue(fees, fee, maxSupply)
+		if !ok {
+			return ruleError(ErrBadCoinbaseValue, fmt.Sprintf("block fees add up to more than the max supply %d", maxSupply))
+		}
+
+		view.addOutputs(&tx, block.Height)
+		pending[hex.EncodeToString(tx.ID)] = tx
+	}
+
+	coinbaseValue := 0
+	for _, out := range block.Transactions[0].Outputs {
+		var ok bool
+		coinbaseValue, ok = addValue(coinbaseValue, out.Value, maxSupply)
+		if !ok {
+			return ruleError(ErrBadCoinbaseValue, fmt.Sprintf("coinbase pays more than the max supply %d", maxSupply))
+		}
+	}
+
+	maxValue := CalcBlockSubsidy(block.Height, chain.Params) + fees
+	if coinbaseValue > maxValue {
+		return ruleError(ErrBadCoinbaseValue, fmt.Sprintf("coinbase pays %d, more than the allowed %d", coinbaseValue, maxValue))
+	}
+
+	return nil
+}
+
+// CheckTransaction validates a loose transaction against the UTXO set of the
+// active chain and returns the fee it pays, its inputs minus its outputs.
+// The transaction may also spend the outputs of the unconfirmed transactions
+// in pending, keyed by their hex encoded IDs.
+func (chain *BlockChain) CheckTransaction(tx *Transaction, pending map[string]Transaction) (int, error) {
+	err := CheckTransactionSanity(tx, chain.Params)
+	if err != nil {
+		return 0, err
+	}
+
+	if tx.IsCoinbase() {
+		return 0, ruleError(ErrLooseCoinbase, fmt.Sprintf("transaction %x is a coinbase outside of a block", tx.ID))
+	}
+
+	height := chain.GetBestHeight() + 1
+
+	view := newUtxoView(&UTXOSet{chain})
+	for _, parent := range pending {
+		view.addOutputs(&parent, height)
+	}
+
+	return chain.checkTransactionInputs(tx, height, view, pending)
+}
+
+// checkTransactionInputs makes sure every input of tx spends an output that
+// is still available in the view, and mature for a block at the given
+// height, that the key of every input owns the output it spends, that the
+// signatures are valid and that the transaction does not create value. The
+// inputs are marked as spent in the view and the fee paid is returned.
+func (chain *BlockChain) checkTransactionInputs(tx *Transaction, height int, view *utxoView, pending map[string]Transaction) (int, error) {
+	maxSupply := chain.Params.MaxSupply
+
+	totalIn := 0
+	for _, in := range tx.Inputs {
+		key := outpointKey(in.ID, in.Out)
+		if view.isSpent(in.ID, in.Out) {
+			return 0, ruleError(ErrDoubleSpend, fmt.Sprintf("transaction %x spends %s already spent in this block", tx.ID, key))
+		}
+
+		entry, ok := view.fetch(in.ID, in.Out)
+		if !ok {
+			return 0, ruleError(ErrMissingTxOut, fmt.Sprintf("transaction %x spends missing or spent output %s", tx.ID, key))
+		}
+
+		if !entry.IsMature(height, chain.Params.CoinbaseMaturity) {
+			return 0, ruleError(ErrImmatureSpend, fmt.Sprintf("transaction %x spends coinbase output %s of height %d at height %d, before maturity", tx.ID, key, entry.Height, height))
+		}
+
+		if !in.UsesKey(entry.Output.PubKeyHash) {
+			return 0, ruleError(ErrWrongOwner, fmt.Sprintf("transaction %x spends output %s with a key that does not own it", tx.ID, key))
+		}
+
+		totalIn, ok = addValue(totalIn, entry.Output.Value, maxSupply)
+		if !ok {
+			return 0, ruleError(ErrBadTxOutValue, fmt.Sprintf("transaction %x spends more than the max supply %d", tx.ID, maxSupply))
+		}
+		view.spend(in.ID, in.Out)
+	}
+
+	totalOut := 0
+	for _, out := range tx.Outputs {
+		var ok bool
+		totalOut, ok = addValue(totalOut, out.Value, maxSupply)
+		if !ok {
+			return 0, ruleError(ErrBadTxOutValue, fmt.Sprintf("transaction %x pays out more than the max supply %d", tx.ID, maxSupply))
+		}
+	}
+
+	if totalOut > totalIn {
+		return 0, ruleError(ErrSpendTooHigh, fmt.Sprintf("transaction %x spends %d but its inputs hold %d", tx.ID, totalOut, totalIn))
+	}
+
+	prevTXs, err := chain.findPrevTransactions(tx, pending)
+	if err != nil || !tx.Verify(prevTXs) {
+		return 0, ruleError(ErrBadSignature, fmt.Sprintf("transaction %x has an invalid signature", tx.ID))
+	}
+
+	return totalIn - totalOut, nil
+}
+
+// utxoView layers the outputs created and spent by the transactions of a
+// block on top of the UTXO set, so later transactions in the block can
+// spend the outputs of earlier ones.
+type utxoView struct {
+	set     *UTXOSet
+	created map[string]UtxoEntry
+	spent   map[string]bool
+}
+
+func newUtxoView(set *UTXOSet) *utxoView {
+	return &utxoView{
+		set:     set,
+		created: make(map[string]UtxoEntry),
+		spent:   make(map[string]bool),
+	}
+}
+
+func (v *utxoView) fetch(txID []byte, outIdx int) (UtxoEntry, bool) {
+	if entry, ok := v.created[outpointKey(txID, outIdx)]; ok {
+		return entry, true
+	}
+
+	return v.set.FetchEntry(txID, outIdx)
+}
+
+func (v *utxoView) isSpent(txID []byte, outIdx int) bool {
+	return v.spent[outpointKey(txID, outIdx)]
+}
+
+func (v *utxoView) spend(txID []byte, outIdx int) {
+	v.spent[outpointKey(txID, outIdx)] = true
+}
+
+func (v *utxoView) addOutputs(tx *Transaction, height int) {
+	for outIdx, out := range tx.Outputs {
+		v.created[outpointKey(tx.ID, outIdx)] = UtxoEntry{
+			Output:   out,
+			Coinbase: tx.IsCoinbase(),
+			Height:   height,
+		}
+	}
+}
+
+func outpointKey(txID []byte, outIdx int) string {
+	return fmt.Sprintf("%x:%d", txID, outIdx)
+}
+
+// addValue adds value to a running total of coins. It fails when the value
+// is negative or when either of them goes past max, so the total can never
+// overflow and wrap around.
+func addValue(total, value, max int) (int, bool) {
+	if value < 0 || value > max || total > max-value {
+		return total, false
+	}
+
+	return total + value, true
+}
@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"errors"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+	"github.com/dev-rodrigobaliza/go-blockchain/wallet"
+)
+
+// newTestChain creates a regtest chain in a temporary directory, with the
+// genesis coinbase paying to owner.
+func newTestChain(t *testing.T, owner *wallet.Wallet) *BlockChain {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+
+	chain := InitBlockChain(string(owner.Address(&params.RegTest)), "test", &params.RegTest)
+	t.Cleanup(func() {
+		chain.Database.Close()
+	})
+
+	UTXOSet := UTXOSet{chain}
+	UTXOSet.Reindex()
+
+	return chain
+}
+
+func TestSpendOutputOfAnotherWallet(t *testing.T) {
+	owner := wallet.NewWallet()
+	thief := wallet.NewWallet()
+	chain := newTestChain(t, owner)
+
+	genesis, err := chain.GetBlock(chain.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := genesis.Transactions[0]
+
+	// the thief signs with its own key an input spending the owner output
+	thiefAddress := string(thief.Address(&params.RegTest))
+	tx := Transaction{
+		Inputs:  []TxInput{NewTxInput(coinbase.ID, 0, nil, thief.PublicKey)},
+		Outputs: []TxOutput{*NewTxOutput(coinbase.Outputs[0].Value, thiefAddress)},
+	}
+	chain.SignTransaction(tx, *thief.GetPrivateKey())
+	tx.ID = tx.Hash()
+
+	_, err = chain.CheckTransaction(&tx, nil)
+
+	var ruleErr RuleError
+	if !errors.As(err, &ruleErr) || ruleErr.ErrorCode != ErrWrongOwner {
+		t.Fatalf("CheckTransaction returned %v, want %v", err, ErrWrongOwner)
+	}
+
+	if chain.VerifyTransaction(tx) {
+		t.Fatal("VerifyTransaction accepted a key that does not own the output")
+	}
+}
+
+func TestOutputValuesOverflow(t *testing.T) {
+	owner := wallet.NewWallet()
+	chain := newTestChain(t, owner)
+
+	genesis, err := chain.GetBlock(chain.Tip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase := genesis.Transactions[0]
+	address := string(owner.Address(&params.RegTest))
+
+	tests := []struct {
+		name   string
+		values []int
+	}{
+		// the sum wraps around to a negative value, below the inputs
+		{"overflow", []int{math.MaxInt/2 + 1, math.MaxInt/2 + 1, 1}},
+		{"above max supply", []int{params.RegTest.MaxSupply, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := Transaction{Inputs: []TxInput{NewTxInput(coinbase.ID, 0, nil, owner.PublicKey)}}
+			for _, value := range test.values {
+				tx.Outputs = append(tx.Outputs, *NewTxOutput(value, address))
+			}
+			chain.SignTransaction(tx, *owner.GetPrivateKey())
+			tx.ID = tx.Hash()
+
+			_, err := chain.CheckTransaction(&tx, nil)
+
+			var ruleErr RuleError
+			if !errors.As(err, &ruleErr) || ruleErr.ErrorCode != ErrBadTxOutValue {
+				t.Fatalf("CheckTransaction returned %v, want %v", err, ErrBadTxOutValue)
+			}
+		})
+	}
+}
@@ -1,297 +1,669 @@
-package cli
-
-import (
-	"errors"
-	"flag"
-	"fmt"
-	"log"
-	"os"
-	"runtime"
-	"strconv"
-
-	"github.com/dev-rodrigobaliza/go-blockchain/base58"
-	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
-	"github.com/dev-rodrigobaliza/go-blockchain/network"
-	"github.com/dev-rodrigobaliza/go-blockchain/utils"
-	"github.com/dev-rodrigobaliza/go-blockchain/wallet"
-)
-
-type CommandLine struct{}
-
-func (cli *CommandLine) printUsage() {
-	fmt.Println("Usage:")
-	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
-	fmt.Println(" createblockchain -address ADDRESS - create the blockchain for the given address")
-	fmt.Println(" printchain - prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT -mine - send amount from one address to another address. Then -mine enables do this transaction without miners")
-	fmt.Println(" createwallet - creates a new Wallet")
-	fmt.Println(" listaddresses - lists the addresses in the wallet file")
-	fmt.Println(" reindexutxo - rebuilds the UTXO set")
-	fmt.Println(" startnode -miner ADDRESS - start a node with ID specified in NODE_ID env. var. -miner enables mining")
-}
-
-func (cli *CommandLine) validateArgs() {
-	if len(os.Args) < 2 {
-		cli.printUsage()
-		runtime.Goexit()
-	}
-}
-
-func (cli *CommandLine) startNode(nodeId, minerAddress string) {
-	fmt.Printf("Starting node %s\n", nodeId)
-
-	if len(minerAddress) > 0 {
-		if !wallet.ValidateAddress(minerAddress) {
-			utils.Handle(errors.New("wrong miner address"))
-		}
-
-		fmt.Println("Mining is on, address to receive rewards: ", minerAddress)
-	}
-
-	network.StartServer(nodeId, minerAddress)
-}
-
-func (cli *CommandLine) reindexUTXO(nodeId string) {
-	chain := blockchain.ContinueBlockChain(nodeId)
-	defer chain.Database.Close()
-
-	UTXOSet := blockchain.UTXOSet{
-		Blockchain: chain,
-	}
-	UTXOSet.Reindex()
-
-	count := UTXOSet.CountTransactions()
-	fmt.Printf("Done, there are %d transactions in the UTXO set.\n", count)
-}
-
-func (cli *CommandLine) listAddresses(nodeId string) {
-	wallets, err := wallet.NewWallets(nodeId)
-	utils.Handle(err)
-	addresses := wallets.GetAllAddresses()
-
-	for _, address := range addresses {
-		fmt.Println(address)
-	}
-}
-
-func (cli *CommandLine) createWallet(nodeId string) {
-	wallets, err := wallet.NewWallets(nodeId)
-	utils.Handle(err)
-	address := wallets.AddWallet()
-	wallets.SaveFile(nodeId)
-
-	fmt.Printf("New address: %s\n", address)
-}
-
-func (cli *CommandLine) printChain(nodeId string) {
-	chain := blockchain.ContinueBlockChain(nodeId)
-	defer chain.Database.Close()
-
-	iter := chain.Iterator()
-
-	for {
-		block := iter.Next()
-
-		fmt.Printf("Hash: %x\n", block.Hash)
-		fmt.Printf("Previous Hash: %x\n", block.PrevHash)
-		pow := blockchain.NewProof(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
-		}
-		fmt.Println()
-
-		if len(block.PrevHash) == 0 {
-			break
-		}
-	}
-}
-
-func (cli *CommandLine) createBlockChain(address, nodeId string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not valid")
-	}
-
-	chain := blockchain.InitBlockChain(address, nodeId)
-	defer chain.Database.Close()
-
-	UTXOSet := blockchain.UTXOSet{
-		Blockchain: chain,
-	}
-	UTXOSet.Reindex()
-
-	fmt.Println("Finished")
-}
-
-func (cli *CommandLine) getBalance(address, nodeId string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not valid")
-	}
-
-	chain := blockchain.ContinueBlockChain(nodeId)
-	defer chain.Database.Close()
-
-	UTXOSet := blockchain.UTXOSet{
-		Blockchain: chain,
-	}
-	balance := 0
-	pubKeyHash := base58.Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-wallet.ChecksumLength]
-	UTXOs := UTXOSet.FindUnspentTransactions(pubKeyHash)
-
-	for _, out := range UTXOs {
-		balance += out.Value
-	}
-
-	fmt.Printf("Balance of %s: %d\n", address, balance)
-}
-
-func (cli *CommandLine) send(from, to string, amount int, nodeId string, mineNow bool) {
-	if !wallet.ValidateAddress(from) {
-		log.Panic("From address is not valid")
-	}
-
-	if !wallet.ValidateAddress(to) {
-		log.Panic("To address is not valid")
-	}
-
-	chain := blockchain.ContinueBlockChain(nodeId)
-	defer chain.Database.Close()
-
-	UTXOSet := &blockchain.UTXOSet{
-		Blockchain: chain,
-	}
-
-	wallets, err := wallet.NewWallets(nodeId)
-	utils.Handle(err)
-
-	wallet := wallets.GetWallet(from)
-
-	tx := blockchain.NewTransaction(wallet, to, amount, UTXOSet)
-	if mineNow {
-		cbTx := blockchain.CoinbaseTx(from, "")
-		txs := []blockchain.Transaction{cbTx, tx}
-		block := chain.MineBlock(txs)
-		UTXOSet.Update(block)
-	} else {
-		network.SendTx(network.KnownNodes[0], tx)
-		fmt.Println("send tx")
-	}
-
-	fmt.Println("Success")
-}
-
-func (cli *CommandLine) Run() {
-	cli.validateArgs()
-
-	nodeId := os.Getenv("NODE_ID")
-	if nodeId == "" {
-		fmt.Println("NODE_ID env is not set")
-		runtime.Goexit()
-	}
-
-	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
-	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
-	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
-	printChainCmd := flag.NewFlagSet("print", flag.ExitOnError)
-	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
-	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
-	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
-	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
-
-	getBalanceAddress := getBalanceCmd.String("address", "", "The address of the account")
-	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address of the account")
-	sendFrom := sendCmd.String("from", "", "Source wallet address")
-	sendTo := sendCmd.String("to", "", "Destination wallet address")
-	sendAmount := sendCmd.Int("amount", 0, "Amount to sendt")
-	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
-	startNodeMiner := startNodeCmd.String("miner", "", "Enable minig mode and send reward")
-
-	switch os.Args[1] {
-	case "startnode":
-		err := startNodeCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-
-	case "reindexutxo":
-		err := reindexUTXOCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-
-	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-
-	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-
-	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-
-	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-
-	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-
-	case "send":
-		err := sendCmd.Parse(os.Args[2:])
-		utils.Handle(err)
-
-	default:
-		cli.printUsage()
-		runtime.Goexit()
-	}
-
-	if startNodeCmd.Parsed() {
-		nodeId := os.Getenv("NODE_ID")
-		if nodeId == "" {
-			startNodeCmd.Usage()
-			runtime.Goexit()
-		}
-
-		cli.startNode(nodeId, *startNodeMiner)
-	}
-
-	if reindexUTXOCmd.Parsed() {
-		cli.reindexUTXO(nodeId)
-	}
-
-	if createWalletCmd.Parsed() {
-		cli.createWallet(nodeId)
-	}
-
-	if listAddressesCmd.Parsed() {
-		cli.listAddresses(nodeId)
-	}
-
-	if getBalanceCmd.Parsed() {
-		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
-			runtime.Goexit()
-		}
-		cli.getBalance(*getBalanceAddress, nodeId)
-	}
-
-	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
-			createBlockchainCmd.Usage()
-			runtime.Goexit()
-		}
-		cli.createBlockChain(*createBlockchainAddress, nodeId)
-	}
-
-	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
-			sendCmd.Usage()
-			runtime.Goexit()
-		}
-		cli.send(*sendFrom, *sendTo, *sendAmount, nodeId, *sendMine)
-	}
-
-	if printChainCmd.Parsed() {
-		cli.printChain(nodeId)
-	}
-}
+package cli
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/base58"
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+	"github.com/dev-rodrigobaliza/go-blockchain/mining"
+	"github.com/dev-rodrigobaliza/go-blockchain/network"
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	"github.com/dev-rodrigobaliza/go-blockchain/wallet"
+)
+
+type CommandLine struct {
+	params *params.ChainParams
+}
+
+func (cli *CommandLine) printUsage() {
+	fmt.Println("Usage:")
+	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
+	fmt.Println(" createblockchain -address ADDRESS - create the blockchain for the given address")
+	fmt.Println(" printchain - prints the blocks in the chain")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT [-fee FEE | -feerate RATE] -rbf -mine - send amount from one address to another address, paying a fixed fee or a fee per 1000 bytes. Then -rbf allows the fee to be bumped later and -mine enables do this transaction without miners")
+	fmt.Println(" bumpfee -txid TXID [-fee FEE | -feerate RATE] - replaces an unconfirmed -rbf transaction with one paying a higher fee, twice the current one by default")
+	fmt.Println(" createwallet - creates a new Wallet")
+	fmt.Println(" listaddresses - lists the addresses in the wallet file")
+	fmt.Println(" reindexutxo - rebuilds the UTXO set")
+	fmt.Println(" reindextx - builds the transaction index, which is then kept up to date")
+	fmt.Println(" reindexaddr - builds the address index, which is then kept up to date")
+	fmt.Println(" listaddresstxs -address ADDRESS - lists the outputs paying to an address and the inputs spending them, with the balance after each block")
+	fmt.Println(" gettransaction -txid TXID - prints a transaction of the active chain and the block holding it")
+	fmt.Println(" getmerkleproof -txid TXID - asks the running node for the proof that a confirmed transaction is in its block and checks it against the block header")
+	fmt.Println(" getblockhash -height HEIGHT - prints the hash of the block at the given height of the active chain")
+	fmt.Println(" gettxoutsetinfo - shows statistics about the UTXO set, including the total supply")
+	fmt.Println(" estimatefee -blocks N - asks the running node for the fee rate, per 1000 bytes, to confirm within N blocks")
+	fmt.Println(" getblocktemplate -address ADDRESS - asks the running node for a block template paying the coinbase to the address")
+	fmt.Println(" startnode -miner ADDRESS -threads N -blockmaxsize SIZE -blockmaxsigops N -stratum HOST:PORT - start a node with ID specified in NODE_ID env. var. -miner enables mining on N threads, within the given block limits, and -stratum serves jobs to external miners")
+	fmt.Println("The network is chosen with the NETWORK env. var.: mainnet (default), testnet or regtest")
+}
+
+func (cli *CommandLine) validateArgs() {
+	if len(os.Args) < 2 {
+		cli.printUsage()
+		runtime.Goexit()
+	}
+}
+
+func (cli *CommandLine) startNode(nodeId, minerAddress string, threads int, policy *mining.Policy, stratumAddress string) {
+	fmt.Printf("Starting node %s\n", nodeId)
+
+	if len(minerAddress) > 0 {
+		if !wallet.ValidateAddress(minerAddress, cli.params) {
+			utils.Handle(errors.New("wrong miner address"))
+		}
+
+		fmt.Println("Mining is on, address to receive rewards: ", minerAddress)
+	}
+
+	network.StartServer(nodeId, minerAddress, threads, policy, stratumAddress, cli.params)
+}
+
+func (cli *CommandLine) reindexUTXO(nodeId string) {
+	chain := blockchain.ContinueBlockChain(nodeId, cli.params)
+	defer chain.Database.Close()
+
+	UTXOSet := blockchain.UTXOSet{
+		Blockchain: chain,
+	}
+	UTXOSet.Reindex()
+
+	count := UTXOSet.CountTransactions()
+	fmt.Printf("Done, there are %d transactions in the UTXO set.\n", count)
+}
+
+func (cli *CommandLine) reindexTx(nodeId string) {
+	chain := blockchain.ContinueBlockChain(nodeId, cli.params)
+	defer chain.Database.Close()
+
+	count, err := chain.BuildTxIndex()
+	utils.Handle(err)
+
+	fmt.Printf("Done, there are %d transactions in the index.\n", count)
+}
+
+func (cli *CommandLine) reindexAddr(nodeId string) {
+	chain := blockchain.ContinueBlockChain(nodeId, cli.params)
+	defer chain.Database.Close()
+
+	count, err := chain.BuildAddrIndex()
+	utils.Handle(err)
+
+	fmt.Printf("Done, there are %d events in the index.\n", count)
+}
+
+func (cli *CommandLine) listAddressTxs(address, nodeId string) {
+	if !wallet.ValidateAddress(address, cli.params) {
+		log.Panic("Address is not valid")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeId, cli.params)
+	defer chain.Database.Close()
+
+	pubKeyHash := base58.Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-wallet.ChecksumLength]
+
+	events, err := chain.GetAddressHistory(pubKeyHash)
+	utils.Handle(err)
+
+	balance := 0
+	for _, event := range events {
+		if event.Spend {
+			balance -= event.Value
+			fmt.Printf("Height %d tx %x input %d: -%d, balance %d\n", event.Height, event.TxID, event.Index, event.Value, balance)
+		} else {
+			balance += event.Value
+			fmt.Printf("Height %d tx %x output %d: +%d, balance %d\n", event.Height, event.TxID, event.Index, event.Value, balance)
+		}
+	}
+
+	fmt.Printf("Balance of %s: %d\n", address, balance)
+}
+
+func (cli *CommandLine) getTransaction(nodeId, txID string) {
+	id, err := hex.DecodeString(txID)
+	utils.Handle(err)
+
+	chain := blockchain.ContinueBlockChain(nodeId, cli.params)
+	defer chain.Database.Close()
+
+	tx, blockHash, err := chain.LocateTransaction(id)
+	utils.Handle(err)
+
+	fmt.Printf("Block: %x\n", blockHash)
+	fmt.Println(tx)
+}
+
+func (cli *CommandLine) getMerkleProof(nodeId, txID string) {
+	id, err := hex.DecodeString(txID)
+	utils.Handle(err)
+
+	proof, err := network.GetTxProof(cli.params.NodeAddress(nodeId), id)
+	utils.Handle(err)
+
+	fmt.Printf("Block: %x\n", proof.BlockHash)
+	fmt.Printf("Height: %d\n", proof.Header.Height)
+	fmt.Printf("Merkle Root: %x\n", proof.Header.MerkleRoot)
+	fmt.Printf("Position: %d\n", proof.Proof.Index)
+	for _, sibling := range proof.Proof.Siblings {
+		side := "right"
+		if sibling.Left {
+			side = "left"
+		}
+		fmt.Printf("  %x (%s)\n", sibling.Hash, side)
+	}
+
+	err = proof.Verify()
+	if err != nil {
+		fmt.Printf("Proof invalid: %s\n", err)
+		return
+	}
+	fmt.Println("Proof valid")
+}
+
+func (cli *CommandLine) getBlockHash(nodeId string, height int) {
+	chain := blockchain.ContinueBlockChain(nodeId, cli.params)
+	defer chain.Database.Close()
+
+	hash, err := chain.GetBlockHash(height)
+	utils.Handle(err)
+
+	fmt.Printf("%x\n", hash)
+}
+
+func (cli *CommandLine) getTxOutSetInfo(nodeId string) {
+	chain := blockchain.ContinueBlockChain(nodeId, cli.params)
+	defer chain.Database.Close()
+
+	UTXOSet := blockchain.UTXOSet{
+		Blockchain: chain,
+	}
+	info := UTXOSet.GetTxOutSetInfo()
+
+	fmt.Printf("Height: %d\n", info.Height)
+	fmt.Printf("Best block: %x\n", info.BestBlock)
+	fmt.Printf("Transactions: %d\n", info.Transactions)
+	fmt.Printf("Outputs: %d\n", info.Outputs)
+	fmt.Printf("Total amount: %d\n", info.TotalAmount)
+	fmt.Printf("Max supply: %d\n", cli.params.MaxSupply)
+}
+
+func (cli *CommandLine) estimateFee(nodeId string, blocks int) {
+	feeRate, err := network.EstimateFee(cli.params.NodeAddress(nodeId), blocks)
+	utils.Handle(err)
+
+	fmt.Printf("Fee rate to confirm within %d blocks: %d per 1000 bytes\n", blocks, feeRate)
+}
+
+func (cli *CommandLine) getBlockTemplate(nodeId, address string) {
+	if !wallet.ValidateAddress(address, cli.params) {
+		log.Panic("Address is not valid")
+	}
+
+	template, err := network.GetBlockTemplate(cli.params.NodeAddress(nodeId), address)
+	utils.Handle(err)
+
+	block := template.Block
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Previous Hash: %x\n", block.PrevHash)
+	fmt.Printf("Merkle Root: %x\n", block.MerkleRoot)
+	fmt.Printf("Timestamp: %d\n", block.Timestamp)
+	fmt.Printf("Bits: %08x\n", block.Bits)
+	fmt.Printf("Target: %064x\n", blockchain.CompactToBig(block.Bits))
+	fmt.Printf("Coinbase value: %d\n", block.Transactions[0].Outputs[0].Value)
+	fmt.Printf("Fees: %d\n", template.Fees)
+	fmt.Printf("Size: %d\n", template.Size)
+	fmt.Printf("SigOps: %d\n", template.SigOps)
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+}
+
+func (cli *CommandLine) listAddresses(nodeId string) {
+	wallets, err := wallet.NewWallets(nodeId, cli.params)
+	utils.Handle(err)
+	addresses := wallets.GetAllAddresses()
+
+	for _, address := range addresses {
+		fmt.Println(address)
+	}
+}
+
+func (cli *CommandLine) createWallet(nodeId string) {
+	wallets, err := wallet.NewWallets(nodeId, cli.params)
+	utils.Handle(err)
+	address := wallets.AddWallet()
+	wallets.SaveFile(nodeId)
+
+	fmt.Printf("New address: %s\n", address)
+}
+
+func (cli *CommandLine) printChain(nodeId string) {
+	chain := blockchain.ContinueBlockChain(nodeId, cli.params)
+	defer chain.Database.Close()
+
+	iter := chain.Iterator()
+
+	for {
+		block := iter.Next()
+
+		fmt.Printf("Hash: %x\n", block.Hash)
+		fmt.Printf("Previous Hash: %x\n", block.PrevHash)
+		pow := blockchain.NewProof(block.BlockHeader)
+		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		for _, tx := range block.Transactions {
+			fmt.Println(tx)
+		}
+		fmt.Println()
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+}
+
+func (cli *CommandLine) createBlockChain(address, nodeId string) {
+	if !wallet.ValidateAddress(address, cli.params) {
+		log.Panic("Address is not valid")
+	}
+
+	chain := blockchain.InitBlockChain(address, nodeId, cli.params)
+	defer chain.Database.Close()
+
+	UTXOSet := blockchain.UTXOSet{
+		Blockchain: chain,
+	}
+	UTXOSet.Reindex()
+
+	fmt.Println("Finished")
+}
+
+func (cli *CommandLine) getBalance(address, nodeId string) {
+	if !wallet.ValidateAddress(address, cli.params) {
+		log.Panic("Address is not valid")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeId, cli.params)
+	defer chain.Database.Close()
+
+	UTXOSet := blockchain.UTXOSet{
+		Blockchain: chain,
+	}
+	pubKeyHash := base58.Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-wallet.ChecksumLength]
+	balance, immature := UTXOSet.GetBalance(pubKeyHash)
+
+	fmt.Printf("Balance of %s: %d\n", address, balance)
+	fmt.Printf("Immature balance of %s: %d\n", address, immature)
+}
+
+func (cli *CommandLine) send(from, to string, amount, fee, feeRate int, replaceable bool, nodeId string, mineNow bool) {
+	if !wallet.ValidateAddress(from, cli.params) {
+		log.Panic("From address is not valid")
+	}
+
+	if !wallet.ValidateAddress(to, cli.params) {
+		log.Panic("To address is not valid")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeId, cli.params)
+	defer chain.Database.Close()
+
+	UTXOSet := &blockchain.UTXOSet{
+		Blockchain: chain,
+	}
+
+	wallets, err := wallet.NewWallets(nodeId, cli.params)
+	utils.Handle(err)
+
+	wallet := wallets.GetWallet(from)
+
+	var tx blockchain.Transaction
+	if feeRate > 0 {
+		tx = blockchain.NewTransactionFeeRate(wallet, to, amount, feeRate, replaceable, UTXOSet)
+	} else {
+		tx = blockchain.NewTransaction(wallet, to, amount, fee, replaceable, UTXOSet)
+	}
+
+	if mineNow {
+		txFee, err := chain.CheckTransaction(&tx, nil)
+		utils.Handle(err)
+
+		subsidy := blockchain.CalcBlockSubsidy(chain.GetBestHeight()+1, cli.params)
+		cbTx := blockchain.CoinbaseTx(from, "", subsidy+txFee)
+		txs := []blockchain.Transaction{cbTx, tx}
+		_, err = chain.MineBlock(txs)
+		utils.Handle(err)
+	} else {
+		network.SendTx(cli.params.SeedNodes[0], tx)
+		fmt.Printf("send tx %x\n", tx.ID)
+	}
+
+	fmt.Println("Success")
+}
+
+func (cli *CommandLine) bumpFee(txID string, fee, feeRate int, nodeId string) {
+	id, err := hex.DecodeString(txID)
+	utils.Handle(err)
+
+	tx, oldFee, err := network.GetMempoolTx(cli.params.SeedNodes[0], id)
+	utils.Handle(err)
+
+	wallets, err := wallet.NewWallets(nodeId, cli.params)
+	utils.Handle(err)
+
+	var w *wallet.Wallet
+	for _, address := range wallets.GetAllAddresses() {
+		candidate := wallets.GetWallet(address)
+		if bytes.Equal(candidate.PublicKey, tx.Inputs[0].PubKey) {
+			w = candidate
+			break
+		}
+	}
+
+	if w == nil {
+		log.Panic("Error: transaction was not sent from this wallet file")
+	}
+
+	chain := blockchain.ContinueBlockChain(nodeId, cli.params)
+	defer chain.Database.Close()
+
+	UTXOSet := &blockchain.UTXOSet{
+		Blockchain: chain,
+	}
+
+	newFee := fee
+	if feeRate > 0 {
+		newFee = oldFee + 1
+	} else if newFee == 0 {
+		newFee = 2 * oldFee
+		if newFee <= oldFee {
+			newFee = oldFee + 1
+		}
+	}
+
+	bumped, err := blockchain.BumpFee(w, &tx, oldFee, newFee, UTXOSet)
+	utils.Handle(err)
+
+	// the fee changes the size, so rebuild until the fee covers it
+	for feeRate > 0 && newFee < blockchain.CalcFee(bumped.Size(), feeRate) {
+		newFee = blockchain.CalcFee(bumped.Size(), feeRate)
+		bumped, err = blockchain.BumpFee(w, &tx, oldFee, newFee, UTXOSet)
+		utils.Handle(err)
+	}
+
+	network.SendTx(cli.params.SeedNodes[0], bumped)
+	fmt.Printf("send tx %x replacing %x, fee %d -> %d\n", bumped.ID, tx.ID, oldFee, newFee)
+}
+
+func (cli *CommandLine) Run() {
+	cli.validateArgs()
+
+	nodeId := os.Getenv("NODE_ID")
+	if nodeId == "" {
+		fmt.Println("NODE_ID env is not set")
+		runtime.Goexit()
+	}
+
+	networkName := os.Getenv("NETWORK")
+	if networkName == "" {
+		networkName = params.MainNet.Name
+	}
+
+	chainParams, err := params.ByName(networkName)
+	utils.Handle(err)
+	cli.params = chainParams
+	network.UseChainParams(chainParams)
+
+	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
+	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	printChainCmd := flag.NewFlagSet("print", flag.ExitOnError)
+	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
+	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	getTxOutSetInfoCmd := flag.NewFlagSet("gettxoutsetinfo", flag.ExitOnError)
+	getBlockHashCmd := flag.NewFlagSet("getblockhash", flag.ExitOnError)
+	reindexTxCmd := flag.NewFlagSet("reindextx", flag.ExitOnError)
+	getTransactionCmd := flag.NewFlagSet("gettransaction", flag.ExitOnError)
+	getMerkleProofCmd := flag.NewFlagSet("getmerkleproof", flag.ExitOnError)
+	reindexAddrCmd := flag.NewFlagSet("reindexaddr", flag.ExitOnError)
+	listAddressTxsCmd := flag.NewFlagSet("listaddresstxs", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	estimateFeeCmd := flag.NewFlagSet("estimatefee", flag.ExitOnError)
+	bumpFeeCmd := flag.NewFlagSet("bumpfee", flag.ExitOnError)
+	getBlockTemplateCmd := flag.NewFlagSet("getblocktemplate", flag.ExitOnError)
+
+	getBalanceAddress := getBalanceCmd.String("address", "", "The address of the account")
+	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address of the account")
+	sendFrom := sendCmd.String("from", "", "Source wallet address")
+	sendTo := sendCmd.String("to", "", "Destination wallet address")
+	sendAmount := sendCmd.Int("amount", 0, "Amount to sendt")
+	sendFee := sendCmd.Int("fee", 0, "Fee paid to the miner")
+	sendFeeRate := sendCmd.Int("feerate", 0, "Fee paid to the miner per 1000 bytes of the transaction")
+	sendRBF := sendCmd.Bool("rbf", false, "Allow the transaction to be replaced by one paying a higher fee")
+	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	startNodeMiner := startNodeCmd.String("miner", "", "Enable minig mode and send reward")
+	startNodeThreads := startNodeCmd.Int("threads", 0, "Number of mining threads (defaults to one per CPU)")
+	startNodeStratum := startNodeCmd.String("stratum", "", "Address to serve mining jobs to external miners on")
+	startNodeBlockMaxSize := startNodeCmd.Int("blockmaxsize", 0, "Largest block to mine, in bytes (defaults to the network limit)")
+	startNodeBlockMaxSigOps := startNodeCmd.Int("blockmaxsigops", 0, "Most signature checks in a mined block (defaults to the network limit)")
+	getBlockTemplateAddress := getBlockTemplateCmd.String("address", "", "The address the coinbase pays to")
+	listAddressTxsAddress := listAddressTxsCmd.String("address", "", "The address of the account")
+	getTransactionTxID := getTransactionCmd.String("txid", "", "ID of the transaction")
+	getMerkleProofTxID := getMerkleProofCmd.String("txid", "", "ID of the confirmed transaction")
+	getBlockHashHeight := getBlockHashCmd.Int("height", -1, "Height of the block")
+	estimateFeeBlocks := estimateFeeCmd.Int("blocks", 6, "Number of blocks to confirm within")
+	bumpFeeTxID := bumpFeeCmd.String("txid", "", "ID of the unconfirmed transaction")
+	bumpFeeFee := bumpFeeCmd.Int("fee", 0, "New fee paid to the miner")
+	bumpFeeFeeRate := bumpFeeCmd.Int("feerate", 0, "New fee paid to the miner per 1000 bytes of the transaction")
+
+	switch os.Args[1] {
+	case "startnode":
+		err := startNodeCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "estimatefee":
+		err := estimateFeeCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "getblocktemplate":
+		err := getBlockTemplateCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "bumpfee":
+		err := bumpFeeCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "reindexutxo":
+		err := reindexUTXOCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "gettxoutsetinfo":
+		err := getTxOutSetInfoCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "getblockhash":
+		err := getBlockHashCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "reindextx":
+		err := reindexTxCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "gettransaction":
+		err := getTransactionCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "getmerkleproof":
+		err := getMerkleProofCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "reindexaddr":
+		err := reindexAddrCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "listaddresstxs":
+		err := listAddressTxsCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "createwallet":
+		err := createWalletCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "listaddresses":
+		err := listAddressesCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "getbalance":
+		err := getBalanceCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "createblockchain":
+		err := createBlockchainCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "printchain":
+		err := printChainCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	case "send":
+		err := sendCmd.Parse(os.Args[2:])
+		utils.Handle(err)
+
+	default:
+		cli.printUsage()
+		runtime.Goexit()
+	}
+
+	if startNodeCmd.Parsed() {
+		nodeId := os.Getenv("NODE_ID")
+		if nodeId == "" {
+			startNodeCmd.Usage()
+			runtime.Goexit()
+		}
+
+		policy := &mining.Policy{
+			BlockMaxSize:   *startNodeBlockMaxSize,
+			BlockMaxSigOps: *startNodeBlockMaxSigOps,
+		}
+		cli.startNode(nodeId, *startNodeMiner, *startNodeThreads, policy, *startNodeStratum)
+	}
+
+	if estimateFeeCmd.Parsed() {
+		if *estimateFeeBlocks <= 0 {
+			estimateFeeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.estimateFee(nodeId, *estimateFeeBlocks)
+	}
+
+	if getBlockTemplateCmd.Parsed() {
+		if *getBlockTemplateAddress == "" {
+			getBlockTemplateCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getBlockTemplate(nodeId, *getBlockTemplateAddress)
+	}
+
+	if bumpFeeCmd.Parsed() {
+		if *bumpFeeTxID == "" || *bumpFeeFee < 0 || *bumpFeeFeeRate < 0 || (*bumpFeeFee > 0 && *bumpFeeFeeRate > 0) {
+			bumpFeeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.bumpFee(*bumpFeeTxID, *bumpFeeFee, *bumpFeeFeeRate, nodeId)
+	}
+
+	if reindexUTXOCmd.Parsed() {
+		cli.reindexUTXO(nodeId)
+	}
+
+	if getTxOutSetInfoCmd.Parsed() {
+		cli.getTxOutSetInfo(nodeId)
+	}
+
+	if getBlockHashCmd.Parsed() {
+		if *getBlockHashHeight < 0 {
+			getBlockHashCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getBlockHash(nodeId, *getBlockHashHeight)
+	}
+
+	if reindexTxCmd.Parsed() {
+		cli.reindexTx(nodeId)
+	}
+
+	if getTransactionCmd.Parsed() {
+		if *getTransactionTxID == "" {
+			getTransactionCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getTransaction(nodeId, *getTransactionTxID)
+	}
+
+	if getMerkleProofCmd.Parsed() {
+		if *getMerkleProofTxID == "" {
+			getMerkleProofCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getMerkleProof(nodeId, *getMerkleProofTxID)
+	}
+
+	if reindexAddrCmd.Parsed() {
+		cli.reindexAddr(nodeId)
+	}
+
+	if listAddressTxsCmd.Parsed() {
+		if *listAddressTxsAddress == "" {
+			listAddressTxsCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.listAddressTxs(*listAddressTxsAddress, nodeId)
+	}
+
+	if createWalletCmd.Parsed() {
+		cli.createWallet(nodeId)
+	}
+
+	if listAddressesCmd.Parsed() {
+		cli.listAddresses(nodeId)
+	}
+
+	if getBalanceCmd.Parsed() {
+		if *getBalanceAddress == "" {
+			getBalanceCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getBalance(*getBalanceAddress, nodeId)
+	}
+
+	if createBlockchainCmd.Parsed() {
+		if *createBlockchainAddress == "" {
+			createBlockchainCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.createBlockChain(*createBlockchainAddress, nodeId)
+	}
+
+	if sendCmd.Parsed() {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 || *sendFeeRate < 0 || (*sendFee > 0 && *sendFeeRate > 0) {
+			sendCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, *sendFeeRate, *sendRBF, nodeId, *sendMine)
+	}
+
+	if printChainCmd.Parsed() {
+		cli.printChain(nodeId)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+	"github.com/dev-rodrigobaliza/go-blockchain/stratum"
+)
+
+// hashRateInterval is how often the hash rate is reported.
+const hashRateInterval = 10 * time.Second
+
+func main() {
+	hostname, _ := os.Hostname()
+
+	server := flag.String("server", "localhost:3333", "Address of the mining server of the node")
+	address := flag.String("address", "", "Address the mined blocks pay to")
+	worker := flag.String("worker", hostname, "Name of this worker")
+	threads := flag.Int("threads", runtime.NumCPU(), "Number of mining threads")
+	flag.Parse()
+
+	if *address == "" || *threads <= 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	client, err := stratum.Dial(*server)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	session, err := client.Subscribe(*worker, *address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Subscribed to %s as session %s, mining on %d threads\n", *server, session.SessionID, *threads)
+
+	var hashes atomic.Uint64
+	go reportHashRate(&hashes)
+
+	var cancel context.CancelFunc
+	var wg sync.WaitGroup
+
+	for job := range client.Jobs() {
+		// every job builds on the current tip, so the previous one is
+		// dropped even when it could still be submitted
+		if cancel != nil {
+			cancel()
+			wg.Wait()
+		}
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+
+		err := mineJob(ctx, &wg, client, job, *threads, &hashes)
+		if err != nil {
+			fmt.Printf("Skipping job %s: %s\n", job.JobID, err)
+		}
+	}
+
+	fmt.Println("Connection to the mining server closed")
+}
+
+// mineJob starts searching the nonces of job on the given number of
+// goroutines, each with its own share of the nonce space.
+func mineJob(ctx context.Context, wg *sync.WaitGroup, client *stratum.Client, job stratum.Job, threads int, hashes *atomic.Uint64) error {
+	buffer, err := hex.DecodeString(job.Header)
+	if err != nil {
+		return err
+	}
+
+	var header blockchain.BlockHeader
+	err = header.Deserialize(buffer)
+	if err != nil {
+		return err
+	}
+
+	target, ok := new(big.Int).SetString(job.Target, 16)
+	if !ok {
+		return fmt.Errorf("invalid target %q", job.Target)
+	}
+
+	shareTarget, ok := new(big.Int).SetString(job.ShareTarget, 16)
+	if !ok {
+		return fmt.Errorf("invalid share target %q", job.ShareTarget)
+	}
+
+	fmt.Printf("Mining job %s for block %d\n", job.JobID, job.Height)
+
+	share := uint64(math.MaxUint32)/uint64(threads) + 1
+	for i := 0; i < threads; i++ {
+		start := uint64(i) * share
+		end := start + share - 1
+		if end > math.MaxUint32 {
+			end = math.MaxUint32
+		}
+
+		wg.Add(1)
+		go func(start, end uint32) {
+			defer wg.Done()
+			searchRange(ctx, client, job.JobID, header, target, shareTarget, start, end, hashes)
+		}(uint32(start), uint32(end))
+	}
+
+	return nil
+}
+
+// searchRange submits every share found between the nonces start and end.
+// When the range is exhausted the timestamp moves to the current time and
+// the search starts over.
+func searchRange(ctx context.Context, client *stratum.Client, jobID string, header blockchain.BlockHeader, target, shareTarget *big.Int, start, end uint32, hashes *atomic.Uint64) {
+	pow := &blockchain.ProofOfWork{Header: header, Target: shareTarget}
+	next := start
+
+	for ctx.Err() == nil {
+		nonce, hash, found := pow.Search(ctx, next, end, hashes)
+		if found {
+			submitShare(client, jobID, &pow.Header, nonce, hash, target)
+		}
+
+		if found && nonce < end {
+			next = nonce + 1
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		// the nonce space is exhausted, so wait for the clock to tick
+		for time.Now().Unix() <= pow.Header.Timestamp {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+
+		pow.Header.Timestamp = time.Now().Unix()
+		next = start
+	}
+}
+
+func submitShare(client *stratum.Client, jobID string, header *blockchain.BlockHeader, nonce uint32, hash []byte, target *big.Int) {
+	result, err := client.Submit(stratum.SubmitParams{
+		JobID:     jobID,
+		Nonce:     nonce,
+		Timestamp: header.Timestamp,
+	})
+	if err != nil {
+		fmt.Printf("Share %x rejected: %s\n", hash, err)
+		return
+	}
+
+	if result.Block != "" {
+		fmt.Printf("Block %s found\n", result.Block)
+	} else if new(big.Int).SetBytes(hash).Cmp(target) < 0 {
+		fmt.Printf("Share %x meets the block target but was not accepted as a block\n", hash)
+	} else if result.Accepted {
+		fmt.Printf("Share %x accepted\n", hash)
+	}
+}
+
+func reportHashRate(hashes *atomic.Uint64) {
+	last := uint64(0)
+	ticker := time.NewTicker(hashRateInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		total := hashes.Load()
+		rate := float64(total-last) / hashRateInterval.Seconds()
+		last = total
+
+		fmt.Printf("Hash rate: %.0f hashes/s\n", rate)
+	}
+}
@@ -1,46 +1,75 @@
-package crypto
-
-import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/sha256"
-	"math/big"
-
-	"github.com/dev-rodrigobaliza/go-blockchain/utils"
-	"golang.org/x/crypto/ripemd160"
-)
-
-func NewKeyPair() (*ecdsa.PrivateKey, []byte, []byte) {
-	curve := elliptic.P256()
-
-	private, err := ecdsa.GenerateKey(curve, rand.Reader)
-	utils.Handle(err)
-
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-
-	privateBytes := elliptic.Marshal(private.Curve, private.X, private.Y)
-
-	return private, privateBytes, pub
-}
-
-func PrivateKeyFromBytes(privateBytes []byte) *ecdsa.PrivateKey {
-	private := new(ecdsa.PrivateKey)
-	private.Curve = elliptic.P256()
-	private.D = new(big.Int).SetBytes(privateBytes)
-	private.X, private.Y = elliptic.Unmarshal(elliptic.P256(), privateBytes)
-
-	return private
-}
-
-func PublicKeyHash(pubKey []byte) []byte {
-	pubHash := sha256.Sum256(pubKey)
-
-	hasher := ripemd160.New()
-	_, err := hasher.Write(pubHash[:])
-	utils.Handle(err)
-
-	publicRipMD := hasher.Sum(nil)
-
-	return publicRipMD
-}
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// ErrBadPrivateKey is returned for bytes that are not a private key of the
+// curve.
+var ErrBadPrivateKey = errors.New("bytes are not a private key")
+
+func NewKeyPair() (*ecdsa.PrivateKey, []byte, []byte) {
+	curve := elliptic.P256()
+
+	private, err := ecdsa.GenerateKey(curve, rand.Reader)
+	utils.Handle(err)
+
+	pub := publicKeyBytes(&private.PublicKey)
+
+	privateBytes := make([]byte, 32)
+	private.D.FillBytes(privateBytes)
+
+	return private, privateBytes, pub
+}
+
+// PrivateKeyFromBytes rebuilds a private key from the 32 bytes of its scalar,
+// as returned by NewKeyPair.
+func PrivateKeyFromBytes(privateBytes []byte) (*ecdsa.PrivateKey, error) {
+	private := new(ecdsa.PrivateKey)
+	private.Curve = elliptic.P256()
+	private.D = new(big.Int).SetBytes(privateBytes)
+
+	if len(privateBytes) != 32 || private.D.Sign() == 0 || private.D.Cmp(private.Curve.Params().N) >= 0 {
+		return nil, ErrBadPrivateKey
+	}
+
+	private.X, private.Y = private.Curve.ScalarBaseMult(privateBytes)
+
+	return private, nil
+}
+
+// PublicKeyBytes returns the public key of a private key the way NewKeyPair
+// does.
+func PublicKeyBytes(private *ecdsa.PrivateKey) []byte {
+	return publicKeyBytes(&private.PublicKey)
+}
+
+// publicKeyBytes encodes both coordinates of the key with a fixed length, so
+// they can be split back in halves.
+func publicKeyBytes(pub *ecdsa.PublicKey) []byte {
+	buffer := make([]byte, 64)
+	pub.X.FillBytes(buffer[:32])
+	pub.Y.FillBytes(buffer[32:])
+
+	return buffer
+}
+
+func PublicKeyHash(pubKey []byte) []byte {
+	pubHash := sha256.Sum256(pubKey)
+
+	hasher := ripemd160.New()
+	_, err := hasher.Write(pubHash[:])
+	utils.Handle(err)
+
+	publicRipMD := hasher.Sum(nil)
+
+	return publicRipMD
+}
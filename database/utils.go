@@ -1,25 +1,25 @@
-package database
-
-import (
-	"fmt"
-	"os"
-	"path/filepath"
-
-	"github.com/dev-rodrigobaliza/go-blockchain/utils"
-)
-
-const (
-	databasePath = "db"
-	blocksPath   = "blocks_%s"
-)
-
-func checkBlockPath(nodeId string) string {
-	systemPath := utils.CheckSystemPath()
-	dbPath := filepath.Join(systemPath, databasePath)
-	_ = os.Mkdir(dbPath, os.ModePerm)
-
-	dbName := fmt.Sprintf(blocksPath, nodeId)
-	blockPath := filepath.Join(dbPath, dbName)
-
-	return blockPath
-}
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+)
+
+const (
+	databasePath = "db"
+	blocksPath   = "blocks_%s"
+)
+
+func checkBlockPath(network, nodeId string) string {
+	systemPath := utils.CheckSystemPath()
+	dbPath := filepath.Join(systemPath, databasePath, network)
+	_ = os.MkdirAll(dbPath, os.ModePerm)
+
+	dbName := fmt.Sprintf(blocksPath, nodeId)
+	blockPath := filepath.Join(dbPath, dbName)
+
+	return blockPath
+}
@@ -0,0 +1,211 @@
+package fees
+
+import (
+	"errors"
+	"math/bits"
+	"sync"
+)
+
+const (
+	// DefaultMaxConfirms is the longest confirmation target tracked.
+	DefaultMaxConfirms = 25
+
+	// successThreshold is the share of transactions of a fee rate bucket
+	// that must confirm within the target for the bucket to be enough.
+	successThreshold = 0.85
+
+	// sufficientTxs is the number of data points a group of buckets needs
+	// before its success rate is trusted. Groups with less data never give
+	// an estimate, so a handful of lucky transactions cannot set the fee.
+	sufficientTxs = 20.0
+
+	// decay is applied to every data point on each new block, so old
+	// blocks weigh less than recent ones.
+	decay = 0.998
+
+	// numBuckets is the number of fee rate buckets. Bucket i holds rates
+	// whose bit length is i, so each bucket doubles the one below it.
+	numBuckets = 32
+)
+
+// ErrInsufficientData is returned when no fee rate has been seen, in at
+// least sufficientTxs transactions, confirming often enough within the
+// requested number of blocks.
+var ErrInsufficientData = errors.New("not enough data to estimate the fee")
+
+// observedTx is a transaction waiting in the mempool.
+type observedTx struct {
+	bucket int
+	height int
+}
+
+// FeeEstimator learns which fee rates get confirmed quickly by tracking how
+// many blocks the transactions seen in the mempool wait before being mined.
+// Fee rates are expressed per 1000 bytes.
+type FeeEstimator struct {
+	mu sync.Mutex
+
+	maxConfirms int
+	bestHeight  int
+
+	// confirmed[b][n] counts the transactions of bucket b that confirmed
+	// after n+1 blocks.
+	confirmed [numBuckets][]float64
+
+	// failed counts the transactions of each bucket that waited longer
+	// than maxConfirms blocks.
+	failed [numBuckets]float64
+
+	observed map[string]observedTx
+}
+
+// NewFeeEstimator returns an estimator tracking confirmation targets of up
+// to maxConfirms blocks.
+func NewFeeEstimator(maxConfirms int) *FeeEstimator {
+	e := &FeeEstimator{
+		maxConfirms: maxConfirms,
+		observed:    make(map[string]observedTx),
+	}
+
+	for b := range e.confirmed {
+		e.confirmed[b] = make([]float64, maxConfirms)
+	}
+
+	return e
+}
+
+// ObserveTransaction records a transaction entering the mempool with the
+// given fee rate while the tip of the chain is at height.
+func (e *FeeEstimator) ObserveTransaction(txID string, feeRate, height int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.observed[txID]; ok {
+		return
+	}
+
+	e.observed[txID] = observedTx{bucket: bucketIndex(feeRate), height: height}
+	if height > e.bestHeight {
+		e.bestHeight = height
+	}
+}
+
+// RemoveTransaction forgets a transaction that left the mempool without
+// being confirmed.
+func (e *FeeEstimator) RemoveTransaction(txID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.observed, txID)
+}
+
+// RegisterBlock records the confirmation of the observed transactions in
+// the block connected at height.
+func (e *FeeEstimator) RegisterBlock(height int, txIDs []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for b := range e.confirmed {
+		for n := range e.confirmed[b] {
+			e.confirmed[b][n] *= decay
+		}
+		e.failed[b] *= decay
+	}
+
+	for _, txID := range txIDs {
+		tx, ok := e.observed[txID]
+		if !ok {
+			continue
+		}
+		delete(e.observed, txID)
+
+		blocks := height - tx.height
+		if blocks < 1 {
+			continue
+		}
+
+		if blocks > e.maxConfirms {
+			e.failed[tx.bucket]++
+		} else {
+			e.confirmed[tx.bucket][blocks-1]++
+		}
+	}
+
+	if height > e.bestHeight {
+		e.bestHeight = height
+	}
+}
+
+// EstimateFee returns the lowest fee rate, per 1000 bytes, that has been
+// confirmed within the given number of blocks with high probability.
+// Buckets are walked from the highest fee rate down, grouping them until
+// they hold enough data, and the walk stops at the first group that does
+// not confirm fast enough.
+func (e *FeeEstimator) EstimateFee(blocks int) (int, error) {
+	if blocks < 1 || blocks > e.maxConfirms {
+		return 0, errors.New("confirmation target out of range")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	waiting := [numBuckets]float64{}
+	for _, tx := range e.observed {
+		if e.bestHeight-tx.height >= blocks {
+			waiting[tx.bucket]++
+		}
+	}
+
+	best := -1
+	within, total := 0.0, 0.0
+
+	for b := numBuckets - 1; b >= 0; b-- {
+		for n, count := range e.confirmed[b] {
+			if n < blocks {
+				within += count
+			}
+			total += count
+		}
+		total += e.failed[b] + waiting[b]
+
+		if total < sufficientTxs {
+			continue
+		}
+
+		if within/total < successThreshold {
+			break
+		}
+
+		best = b
+		within, total = 0, 0
+	}
+
+	if best < 0 {
+		return 0, ErrInsufficientData
+	}
+
+	return bucketFeeRate(best), nil
+}
+
+// bucketIndex returns the bucket of a fee rate.
+func bucketIndex(feeRate int) int {
+	if feeRate <= 0 {
+		return 0
+	}
+
+	b := bits.Len(uint(feeRate))
+	if b >= numBuckets {
+		b = numBuckets - 1
+	}
+
+	return b
+}
+
+// bucketFeeRate returns the lowest fee rate of a bucket.
+func bucketFeeRate(bucket int) int {
+	if bucket == 0 {
+		return 0
+	}
+
+	return 1 << (bucket - 1)
+}
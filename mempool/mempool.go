@@ -0,0 +1,512 @@
+package mempool
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+	"github.com/dev-rodrigobaliza/go-blockchain/fees"
+)
+
+// DefaultMaxPoolSize is the default limit, in serialized bytes, of the
+// transactions kept in the pool.
+const DefaultMaxPoolSize = 5 * 1024 * 1024
+
+var (
+	// ErrAlreadyHave is returned when the transaction is already in the
+	// pool.
+	ErrAlreadyHave = errors.New("transaction already in the pool")
+
+	// ErrPoolConflict is returned when the transaction spends an output
+	// already spent by a transaction in the pool that cannot be replaced.
+	ErrPoolConflict = errors.New("transaction conflicts with the pool")
+
+	// ErrInsufficientFee is returned when a replacement does not pay more
+	// than the transactions it replaces.
+	ErrInsufficientFee = errors.New("replacement fee too low")
+
+	// ErrPoolFull is returned when the pool is full and the transaction
+	// does not pay a high enough fee rate to evict others.
+	ErrPoolFull = errors.New("pool is full")
+)
+
+// Config holds what the pool needs from the rest of the node.
+type Config struct {
+	// Chain is used to validate transactions against the UTXO set.
+	Chain *blockchain.BlockChain
+
+	// MaxPoolSize is the limit, in serialized bytes, of the transactions
+	// kept in the pool. Zero means DefaultMaxPoolSize.
+	MaxPoolSize int
+
+	// FeeEstimator, when set, is told about transactions entering and
+	// leaving the pool without being confirmed.
+	FeeEstimator *fees.FeeEstimator
+}
+
+// TxDesc describes a transaction in the pool.
+type TxDesc struct {
+	Tx      blockchain.Transaction
+	Added   time.Time
+	Height  int
+	Fee     int
+	Size    int
+	FeeRate int
+}
+
+// TxPool holds the valid transactions waiting to be mined. It is safe for
+// concurrent use.
+type TxPool struct {
+	mu  sync.RWMutex
+	cfg Config
+
+	pool      map[string]*TxDesc
+	outpoints map[string]*TxDesc
+	totalSize int
+}
+
+// New returns an empty pool.
+func New(cfg *Config) *TxPool {
+	if cfg.MaxPoolSize == 0 {
+		cfg.MaxPoolSize = DefaultMaxPoolSize
+	}
+
+	return &TxPool{
+		cfg:       *cfg,
+		pool:      make(map[string]*TxDesc),
+		outpoints: make(map[string]*TxDesc),
+	}
+}
+
+// MaybeAcceptTransaction validates tx against the UTXO set and the other
+// transactions in the pool and adds it when it is valid. When the pool
+// grows past its limit the transactions paying the lowest fee rate are
+// evicted.
+//
+// The transaction may spend the outputs of other transactions in the pool.
+//
+// A transaction spending outputs already spent in the pool replaces the
+// conflicting transactions, along with their descendants, when all of them
+// signal replacement and it pays both a higher absolute fee than everything
+// it replaces and a higher fee rate than each of the conflicts.
+func (p *TxPool) MaybeAcceptTransaction(tx *blockchain.Transaction) (*TxDesc, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.maybeAcceptTransaction(tx)
+}
+
+func (p *TxPool) maybeAcceptTransaction(tx *blockchain.Transaction) (*TxDesc, error) {
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := p.pool[txID]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrAlreadyHave, txID)
+	}
+
+	conflicts := make(map[string]*TxDesc)
+	for _, in := range tx.Inputs {
+		spender, ok := p.outpoints[outpointKey(in.ID, in.Out)]
+		if !ok {
+			continue
+		}
+
+		if !spender.Tx.SignalsReplacement() {
+			return nil, fmt.Errorf("%w: %s spends %x:%d already spent by %x", ErrPoolConflict, txID, in.ID, in.Out, spender.Tx.ID)
+		}
+		conflicts[hex.EncodeToString(spender.Tx.ID)] = spender
+	}
+
+	replaced := make(map[string]*TxDesc)
+	for _, conflict := range conflicts {
+		p.descendants(conflict, replaced)
+	}
+
+	// the replaced transactions are not there for tx to spend
+	pending := make(map[string]blockchain.Transaction)
+	for id, desc := range p.pool {
+		if _, ok := replaced[id]; !ok {
+			pending[id] = desc.Tx
+		}
+	}
+
+	fee, err := p.cfg.Chain.CheckTransaction(tx, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	size := tx.Size()
+	desc := &TxDesc{
+		Tx:      *tx,
+		Added:   time.Now(),
+		Height:  p.cfg.Chain.GetBestHeight(),
+		Fee:     fee,
+		Size:    size,
+		FeeRate: blockchain.FeeRate(fee, size),
+	}
+
+	err = checkReplacement(desc, conflicts, replaced)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.makeRoom(desc, replaced)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, conflict := range conflicts {
+		p.removeTransaction(conflict)
+	}
+	p.addTransaction(desc)
+
+	return desc, nil
+}
+
+// checkReplacement makes sure desc pays enough to replace the conflicting
+// transactions of the pool and their descendants.
+func checkReplacement(desc *TxDesc, conflicts, replaced map[string]*TxDesc) error {
+	for _, conflict := range conflicts {
+		if desc.FeeRate <= conflict.FeeRate {
+			return fmt.Errorf("%w: fee rate %d of %x is not higher than %d of %x", ErrInsufficientFee, desc.FeeRate, desc.Tx.ID, conflict.FeeRate, conflict.Tx.ID)
+		}
+	}
+
+	replacedFees := 0
+	for _, d := range replaced {
+		replacedFees += d.Fee
+	}
+
+	if len(replaced) > 0 && desc.Fee <= replacedFees {
+		return fmt.Errorf("%w: fee %d of %x is not higher than the %d it replaces", ErrInsufficientFee, desc.Fee, desc.Tx.ID, replacedFees)
+	}
+
+	return nil
+}
+
+// makeRoom evicts the transactions paying the lowest fee rate, along with
+// their descendants, until desc fits in the pool, counting the space freed
+// by the transactions it replaces. The ancestors of desc are never evicted,
+// and nothing is evicted when desc itself would be among the evicted ones.
+func (p *TxPool) makeRoom(desc *TxDesc, replaced map[string]*TxDesc) error {
+	excess := p.totalSize + desc.Size - p.cfg.MaxPoolSize
+	for _, d := range replaced {
+		excess -= d.Size
+	}
+
+	if excess <= 0 {
+		return nil
+	}
+
+	ancestors := p.ancestors(&desc.Tx)
+
+	evict := make(map[string]*TxDesc)
+	for _, d := range p.sortedByFeeRate(false) {
+		if excess <= 0 {
+			break
+		}
+
+		id := hex.EncodeToString(d.Tx.ID)
+		if _, ok := replaced[id]; ok {
+			continue
+		}
+		if _, ok := evict[id]; ok {
+			continue
+		}
+		if _, ok := ancestors[id]; ok {
+			continue
+		}
+
+		if d.FeeRate >= desc.FeeRate {
+			return fmt.Errorf("%w: fee rate %d of %x is too low", ErrPoolFull, desc.FeeRate, desc.Tx.ID)
+		}
+
+		family := make(map[string]*TxDesc)
+		p.descendants(d, family)
+		for id, member := range family {
+			if _, ok := evict[id]; ok {
+				continue
+			}
+			if _, ok := replaced[id]; !ok {
+				excess -= member.Size
+			}
+			evict[id] = member
+		}
+	}
+
+	if excess > 0 {
+		return fmt.Errorf("%w: %x does not fit", ErrPoolFull, desc.Tx.ID)
+	}
+
+	for _, d := range evict {
+		p.removeTransaction(d)
+	}
+
+	return nil
+}
+
+func (p *TxPool) addTransaction(desc *TxDesc) {
+	txID := hex.EncodeToString(desc.Tx.ID)
+
+	p.pool[txID] = desc
+	for _, in := range desc.Tx.Inputs {
+		p.outpoints[outpointKey(in.ID, in.Out)] = desc
+	}
+	p.totalSize += desc.Size
+
+	if p.cfg.FeeEstimator != nil {
+		p.cfg.FeeEstimator.ObserveTransaction(txID, desc.FeeRate, desc.Height)
+	}
+}
+
+// removeTransaction drops a transaction that leaves the pool without being
+// confirmed, along with its descendants, which can no longer be mined.
+func (p *TxPool) removeTransaction(desc *TxDesc) {
+	txID := hex.EncodeToString(desc.Tx.ID)
+	if _, ok := p.pool[txID]; !ok {
+		return
+	}
+
+	for _, child := range p.children(&desc.Tx) {
+		p.removeTransaction(child)
+	}
+
+	p.dropTransaction(desc)
+
+	if p.cfg.FeeEstimator != nil {
+		p.cfg.FeeEstimator.RemoveTransaction(txID)
+	}
+}
+
+// children returns the transactions of the pool spending outputs of tx.
+func (p *TxPool) children(tx *blockchain.Transaction) []*TxDesc {
+	var children []*TxDesc
+	for outIdx := range tx.Outputs {
+		if spender, ok := p.outpoints[outpointKey(tx.ID, outIdx)]; ok {
+			children = append(children, spender)
+		}
+	}
+
+	return children
+}
+
+// parents returns the transactions of the pool whose outputs tx spends.
+func (p *TxPool) parents(tx *blockchain.Transaction) []*TxDesc {
+	var parents []*TxDesc
+	seen := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		inID := hex.EncodeToString(in.ID)
+		if parent, ok := p.pool[inID]; ok && !seen[inID] {
+			parents = append(parents, parent)
+			seen[inID] = true
+		}
+	}
+
+	return parents
+}
+
+// descendants adds desc and every transaction of the pool spending its
+// outputs, directly or not, to set.
+func (p *TxPool) descendants(desc *TxDesc, set map[string]*TxDesc) {
+	id := hex.EncodeToString(desc.Tx.ID)
+	if _, ok := set[id]; ok {
+		return
+	}
+	set[id] = desc
+
+	for _, child := range p.children(&desc.Tx) {
+		p.descendants(child, set)
+	}
+}
+
+// ancestors returns the transactions of the pool that must be mined before
+// tx, keyed by their hex encoded IDs.
+func (p *TxPool) ancestors(tx *blockchain.Transaction) map[string]*TxDesc {
+	set := make(map[string]*TxDesc)
+
+	stack := p.parents(tx)
+	for len(stack) > 0 {
+		desc := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		id := hex.EncodeToString(desc.Tx.ID)
+		if _, ok := set[id]; ok {
+			continue
+		}
+		set[id] = desc
+		stack = append(stack, p.parents(&desc.Tx)...)
+	}
+
+	return set
+}
+
+func (p *TxPool) dropTransaction(desc *TxDesc) {
+	delete(p.pool, hex.EncodeToString(desc.Tx.ID))
+	for _, in := range desc.Tx.Inputs {
+		delete(p.outpoints, outpointKey(in.ID, in.Out))
+	}
+	p.totalSize -= desc.Size
+}
+
+// RemoveTransaction drops tx from the pool, if it is there.
+func (p *TxPool) RemoveTransaction(tx *blockchain.Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if desc, ok := p.pool[hex.EncodeToString(tx.ID)]; ok {
+		p.removeTransaction(desc)
+	}
+}
+
+// removeDoubleSpends drops the transactions of the pool spending any of the
+// outputs spent by tx, unless they are tx itself.
+func (p *TxPool) removeDoubleSpends(tx *blockchain.Transaction) {
+	for _, in := range tx.Inputs {
+		spender, ok := p.outpoints[outpointKey(in.ID, in.Out)]
+		if ok && !bytes.Equal(spender.Tx.ID, tx.ID) {
+			p.removeTransaction(spender)
+		}
+	}
+}
+
+// removeOrphanedSpenders drops the transactions of the pool spending outputs
+// of tx that are no longer in the UTXO set nor in the pool.
+func (p *TxPool) removeOrphanedSpenders(tx *blockchain.Transaction) {
+	if _, ok := p.pool[hex.EncodeToString(tx.ID)]; ok {
+		return
+	}
+
+	utxos := blockchain.UTXOSet{Blockchain: p.cfg.Chain}
+
+	for outIdx := range tx.Outputs {
+		spender, ok := p.outpoints[outpointKey(tx.ID, outIdx)]
+		if !ok {
+			continue
+		}
+
+		if _, ok := utxos.FindOutput(tx.ID, outIdx); !ok {
+			p.removeTransaction(spender)
+		}
+	}
+}
+
+// BlockConnected drops the transactions confirmed by block and the ones
+// conflicting with them.
+func (p *TxPool) BlockConnected(block *blockchain.Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		if desc, ok := p.pool[hex.EncodeToString(tx.ID)]; ok {
+			p.dropTransaction(desc)
+		}
+
+		if !tx.IsCoinbase() {
+			p.removeDoubleSpends(&tx)
+		}
+	}
+}
+
+// BlockDisconnected puts back the transactions of a block that left the
+// active chain, as long as they are still valid. The transactions of the
+// pool spending outputs that no longer exist, such as the ones created by
+// its coinbase, are dropped. It must be called once the UTXO set reflects
+// the new active chain.
+func (p *TxPool) BlockDisconnected(block *blockchain.Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			_, _ = p.maybeAcceptTransaction(&tx)
+		}
+
+		p.removeOrphanedSpenders(&tx)
+	}
+}
+
+// HaveTransaction reports whether the transaction with the given ID is in
+// the pool.
+func (p *TxPool) HaveTransaction(txID []byte) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, ok := p.pool[hex.EncodeToString(txID)]
+
+	return ok
+}
+
+// FetchTransaction returns the transaction with the given ID from the pool.
+func (p *TxPool) FetchTransaction(txID []byte) (blockchain.Transaction, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	desc, ok := p.pool[hex.EncodeToString(txID)]
+	if !ok {
+		return blockchain.Transaction{}, false
+	}
+
+	return desc.Tx, true
+}
+
+// FetchTxDesc returns the description of the transaction with the given ID
+// from the pool.
+func (p *TxPool) FetchTxDesc(txID []byte) (*TxDesc, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	desc, ok := p.pool[hex.EncodeToString(txID)]
+
+	return desc, ok
+}
+
+// Count returns the number of transactions in the pool.
+func (p *TxPool) Count() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.pool)
+}
+
+// Size returns the total serialized size of the transactions in the pool.
+func (p *TxPool) Size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.totalSize
+}
+
+// TxDescs returns the transactions in the pool, highest fee rate first.
+func (p *TxPool) TxDescs() []*TxDesc {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.sortedByFeeRate(true)
+}
+
+// sortedByFeeRate lists the pool by fee rate. Ties are broken by arrival
+// time, so older transactions come first when sorting in descending order
+// and are evicted last.
+func (p *TxPool) sortedByFeeRate(descending bool) []*TxDesc {
+	descs := make([]*TxDesc, 0, len(p.pool))
+	for _, desc := range p.pool {
+		descs = append(descs, desc)
+	}
+
+	sort.Slice(descs, func(i, j int) bool {
+		if descs[i].FeeRate != descs[j].FeeRate {
+			return (descs[i].FeeRate > descs[j].FeeRate) == descending
+		}
+
+		return descs[i].Added.Before(descs[j].Added) == descending
+	})
+
+	return descs
+}
+
+func outpointKey(txID []byte, outIdx int) string {
+	return fmt.Sprintf("%x:%d", txID, outIdx)
+}
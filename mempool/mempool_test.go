@@ -0,0 +1,153 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+	"github.com/dev-rodrigobaliza/go-blockchain/wallet"
+)
+
+// newTestChain creates a regtest chain in a temporary directory, with the
+// genesis coinbase paying to owner.
+func newTestChain(t *testing.T, owner *wallet.Wallet) *blockchain.BlockChain {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+
+	chain := blockchain.InitBlockChain(string(owner.Address(&params.RegTest)), "test", &params.RegTest)
+	t.Cleanup(func() {
+		chain.Database.Close()
+	})
+
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	UTXOSet.Reindex()
+
+	return chain
+}
+
+// spend returns a transaction of owner spending the first output of parent
+// back to owner, leaving fee to the miner.
+func spend(owner *wallet.Wallet, parent blockchain.Transaction, fee int, replaceable bool) blockchain.Transaction {
+	in := blockchain.NewTxInput(parent.ID, 0, nil, owner.PublicKey)
+	if replaceable {
+		in.Sequence = blockchain.MaxRBFSequence
+	}
+
+	address := string(owner.Address(&params.RegTest))
+	tx := blockchain.Transaction{
+		Inputs:  []blockchain.TxInput{in},
+		Outputs: []blockchain.TxOutput{*blockchain.NewTxOutput(parent.Outputs[0].Value-fee, address)},
+	}
+	tx.Sign(owner.GetPrivateKey(), map[string]blockchain.Transaction{hex.EncodeToString(parent.ID): parent})
+	tx.ID = tx.Hash()
+
+	return tx
+}
+
+// mineCoinbase mines an empty block paying to owner and returns its
+// coinbase.
+func mineCoinbase(t *testing.T, chain *blockchain.BlockChain, owner *wallet.Wallet) blockchain.Transaction {
+	t.Helper()
+
+	height := chain.GetBestHeight() + 1
+	coinbase := blockchain.CoinbaseTx(string(owner.Address(&params.RegTest)), "", blockchain.CalcBlockSubsidy(height, &params.RegTest))
+
+	block, err := chain.MineBlock([]blockchain.Transaction{coinbase})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block.Transactions[0]
+}
+
+func TestReplaceByFee(t *testing.T) {
+	owner := wallet.NewWallet()
+	chain := newTestChain(t, owner)
+	coinbase := mineCoinbase(t, chain, owner)
+
+	tests := []struct {
+		name        string
+		replaceable bool
+		fee         int
+		err         error
+	}{
+		{"original does not signal", false, 10, ErrPoolConflict},
+		{"fee rate not higher", true, 2, ErrInsufficientFee},
+		{"fee not higher than package", true, 3, ErrInsufficientFee},
+		{"replaces package", true, 5, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool := New(&Config{Chain: chain})
+
+			// the original and its child pay 4 together
+			original := spend(owner, coinbase, 2, test.replaceable)
+			child := spend(owner, original, 2, false)
+			for _, tx := range []blockchain.Transaction{original, child} {
+				if _, err := pool.MaybeAcceptTransaction(&tx); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			replacement := spend(owner, coinbase, test.fee, true)
+			_, err := pool.MaybeAcceptTransaction(&replacement)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("MaybeAcceptTransaction returned %v, want %v", err, test.err)
+			}
+
+			replaced := test.err == nil
+			if pool.HaveTransaction(replacement.ID) != replaced {
+				t.Errorf("replacement in the pool is %v, want %v", !replaced, replaced)
+			}
+			if pool.HaveTransaction(original.ID) == replaced || pool.HaveTransaction(child.ID) == replaced {
+				t.Errorf("original package in the pool is %v, want %v", replaced, !replaced)
+			}
+		})
+	}
+}
+
+func TestMiningDescsPackageOrder(t *testing.T) {
+	owner := wallet.NewWallet()
+	chain := newTestChain(t, owner)
+	first := mineCoinbase(t, chain, owner)
+	second := mineCoinbase(t, chain, owner)
+
+	// the child pays for its parent, so the package outranks other
+	parent := spend(owner, first, 1, false)
+	other := spend(owner, second, 5, false)
+	child := spend(owner, parent, 10, false)
+
+	pool := New(&Config{Chain: chain})
+	for _, tx := range []blockchain.Transaction{parent, other, child} {
+		if _, err := pool.MaybeAcceptTransaction(&tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := [][]byte{parent.ID, child.ID, other.ID}
+
+	descs := pool.MiningDescs()
+	if len(descs) != len(want) {
+		t.Fatalf("MiningDescs returned %d transactions, want %d", len(descs), len(want))
+	}
+	for i, desc := range descs {
+		if string(desc.Tx.ID) != string(want[i]) {
+			t.Errorf("transaction %d is %x, want %x", i, desc.Tx.ID, want[i])
+		}
+	}
+}
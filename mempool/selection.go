@@ -0,0 +1,158 @@
+package mempool
+
+import (
+	"container/heap"
+	"encoding/hex"
+	"sort"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+)
+
+// MiningDescs returns the transactions in the pool in the order they should
+// be mined. Transactions are ranked by their ancestor fee rate, the fee rate
+// of the package made of the transaction and its unconfirmed ancestors, so a
+// child paying a high fee pulls its parents along. Parents always come
+// before their children.
+//
+// The packages are kept in a heap. Selecting a transaction only takes it out
+// of the packages of its descendants, which go back in the heap with their
+// new fee rate.
+func (p *TxPool) MiningDescs() []*TxDesc {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	packages := make(map[string]*packageEntry, len(p.pool))
+	queue := make(packageQueue, 0, len(p.pool))
+
+	for id, desc := range p.pool {
+		entry := &packageEntry{desc: desc, fee: desc.Fee, size: desc.Size}
+		for _, d := range p.ancestors(&desc.Tx) {
+			entry.fee += d.Fee
+			entry.size += d.Size
+		}
+		entry.rate = blockchain.FeeRate(entry.fee, entry.size)
+
+		packages[id] = entry
+		queue = append(queue, entry)
+	}
+	heap.Init(&queue)
+
+	selected := make(map[string]bool)
+	order := make([]*TxDesc, 0, len(p.pool))
+
+	for queue.Len() > 0 {
+		best := heap.Pop(&queue).(*packageEntry)
+
+		// entries replaced by an update are skipped
+		id := hex.EncodeToString(best.desc.Tx.ID)
+		if selected[id] || packages[id] != best {
+			continue
+		}
+
+		for _, d := range p.unselectedPackage(best.desc, selected) {
+			selected[hex.EncodeToString(d.Tx.ID)] = true
+			order = append(order, d)
+
+			descendants := make(map[string]*TxDesc)
+			p.descendants(d, descendants)
+
+			for descID, desc := range descendants {
+				if selected[descID] {
+					continue
+				}
+
+				entry := packages[descID]
+				updated := &packageEntry{desc: desc, fee: entry.fee - d.Fee, size: entry.size - d.Size}
+				updated.rate = blockchain.FeeRate(updated.fee, updated.size)
+
+				packages[descID] = updated
+				heap.Push(&queue, updated)
+			}
+		}
+	}
+
+	return order
+}
+
+// packageEntry is a transaction waiting to be mined with the fee and size of
+// its package, the transaction and its ancestors not selected yet.
+type packageEntry struct {
+	desc *TxDesc
+	fee  int
+	size int
+	rate int
+}
+
+// packageQueue is a heap of packages, the highest fee rate first and the
+// oldest transaction first among equal rates.
+type packageQueue []*packageEntry
+
+func (q packageQueue) Len() int {
+	return len(q)
+}
+
+func (q packageQueue) Less(i, j int) bool {
+	if q[i].rate != q[j].rate {
+		return q[i].rate > q[j].rate
+	}
+
+	return q[i].desc.Added.Before(q[j].desc.Added)
+}
+
+func (q packageQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *packageQueue) Push(x interface{}) {
+	*q = append(*q, x.(*packageEntry))
+}
+
+func (q *packageQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+
+	return entry
+}
+
+// unselectedPackage returns desc and its ancestors that are not selected
+// yet, parents first and the oldest first among the same depth.
+func (p *TxPool) unselectedPackage(desc *TxDesc, selected map[string]bool) []*TxDesc {
+	pkg := []*TxDesc{desc}
+	for id, d := range p.ancestors(&desc.Tx) {
+		if !selected[id] {
+			pkg = append(pkg, d)
+		}
+	}
+
+	depths := make(map[string]int)
+	sort.SliceStable(pkg, func(i, j int) bool {
+		di, dj := p.depth(pkg[i], depths), p.depth(pkg[j], depths)
+		if di != dj {
+			return di < dj
+		}
+
+		return pkg[i].Added.Before(pkg[j].Added)
+	})
+
+	return pkg
+}
+
+// depth returns the length of the longest chain of unconfirmed ancestors of
+// desc, which is always larger than the depth of any of its parents.
+func (p *TxPool) depth(desc *TxDesc, depths map[string]int) int {
+	id := hex.EncodeToString(desc.Tx.ID)
+	if d, ok := depths[id]; ok {
+		return d
+	}
+
+	d := 0
+	for _, parent := range p.parents(&desc.Tx) {
+		if pd := p.depth(parent, depths) + 1; pd > d {
+			d = pd
+		}
+	}
+	depths[id] = d
+
+	return d
+}
@@ -0,0 +1,187 @@
+package mining
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+)
+
+// extraNonceLength is the number of bytes at the start of the coinbase data
+// overwritten by the extra nonce.
+const extraNonceLength = 8
+
+// ErrMiningCancelled is returned when a mining job is abandoned before a
+// solution is found.
+var ErrMiningCancelled = errors.New("mining job cancelled")
+
+// CPUMiner solves block templates using several goroutines, each searching
+// its own share of the nonce space.
+type CPUMiner struct {
+	threads int
+
+	mu       sync.Mutex
+	hashes   atomic.Uint64
+	started  time.Time
+	finished time.Time
+}
+
+// NewCPUMiner returns a miner using the given number of goroutines, or one
+// per CPU when threads is not positive.
+func NewCPUMiner(threads int) *CPUMiner {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	return &CPUMiner{threads: threads}
+}
+
+// Threads returns the number of goroutines used to mine.
+func (m *CPUMiner) Threads() int {
+	return m.threads
+}
+
+// HashRate returns the hashes per second of the current job, or of the last
+// one when the miner is idle.
+func (m *CPUMiner) HashRate() float64 {
+	m.mu.Lock()
+	started, finished := m.started, m.finished
+	m.mu.Unlock()
+
+	if started.IsZero() {
+		return 0
+	}
+
+	if finished.Before(started) {
+		finished = time.Now()
+	}
+
+	elapsed := finished.Sub(started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64(m.hashes.Load()) / elapsed
+}
+
+// Solve searches for a block meeting the target of the template until one
+// is found or ctx is done, in which case ErrMiningCancelled is returned.
+// When a goroutine runs out of nonces it moves the timestamp to the current
+// time if that changed, or rolls an extra nonce in the coinbase otherwise,
+// and starts over.
+func (m *CPUMiner) Solve(ctx context.Context, template *BlockTemplate) (blockchain.Block, error) {
+	m.mu.Lock()
+	m.hashes.Store(0)
+	m.started = time.Now()
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		m.finished = time.Now()
+		m.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	solved := make(chan blockchain.Block, m.threads)
+	var wg sync.WaitGroup
+
+	share := uint64(math.MaxUint32)/uint64(m.threads) + 1
+	for i := 0; i < m.threads; i++ {
+		start := uint64(i) * share
+		end := start + share - 1
+		if end > math.MaxUint32 {
+			end = math.MaxUint32
+		}
+
+		wg.Add(1)
+		go func(start, end uint32) {
+			defer wg.Done()
+
+			block, ok := m.solveRange(ctx, template, start, end)
+			if ok {
+				solved <- block
+				cancel()
+			}
+		}(uint32(start), uint32(end))
+	}
+
+	wg.Wait()
+
+	select {
+	case block := <-solved:
+		return block, nil
+	default:
+		return blockchain.Block{}, ErrMiningCancelled
+	}
+}
+
+// solveRange searches the nonces from start to end for one goroutine.
+func (m *CPUMiner) solveRange(ctx context.Context, template *BlockTemplate, start, end uint32) (blockchain.Block, bool) {
+	block := copyBlock(&template.Block)
+	extraNonce := uint64(0)
+
+	for ctx.Err() == nil {
+		pow := blockchain.NewProof(block.BlockHeader)
+		nonce, hash, ok := pow.Search(ctx, start, end, &m.hashes)
+		if ok {
+			block.Nonce = nonce
+			block.Hash = hash
+
+			return block, true
+		}
+
+		now := time.Now().Unix()
+		if now > block.Timestamp {
+			block.Timestamp = now
+			continue
+		}
+
+		extraNonce++
+		setExtraNonce(&block, extraNonce)
+	}
+
+	return blockchain.Block{}, false
+}
+
+// setExtraNonce writes the extra nonce in the coinbase data of block and
+// updates the IDs and the merkle root that commit to it.
+func setExtraNonce(block *blockchain.Block, extraNonce uint64) {
+	coinbase := &block.Transactions[0]
+
+	var buf [extraNonceLength]byte
+	binary.BigEndian.PutUint64(buf[:], extraNonce)
+	encoded := []byte(hex.EncodeToString(buf[:]))
+
+	data := coinbase.Inputs[0].PubKey
+	if len(data) < len(encoded) {
+		data = append(make([]byte, len(encoded)-len(data)), data...)
+	}
+	copy(data, encoded)
+
+	coinbase.Inputs[0].PubKey = data
+	coinbase.ID = coinbase.Hash()
+	block.MerkleRoot = block.HashTransactions()
+}
+
+// copyBlock returns a copy of block whose coinbase can be changed without
+// touching the original.
+func copyBlock(block *blockchain.Block) blockchain.Block {
+	cp := *block
+	cp.Transactions = append([]blockchain.Transaction{}, block.Transactions...)
+
+	coinbase := cp.Transactions[0]
+	coinbase.Inputs = append([]blockchain.TxInput{}, coinbase.Inputs...)
+	coinbase.Inputs[0].PubKey = append([]byte{}, coinbase.Inputs[0].PubKey...)
+	cp.Transactions[0] = coinbase
+
+	return cp
+}
@@ -0,0 +1,138 @@
+package mining
+
+import (
+	"encoding/hex"
+	"math"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+	"github.com/dev-rodrigobaliza/go-blockchain/mempool"
+)
+
+// Policy holds the limits of the blocks built by the node. They may be
+// tighter than the consensus limits of the chain params, which are used
+// when left at zero.
+type Policy struct {
+	// BlockMaxSize is the largest serialized block to build, in bytes.
+	BlockMaxSize int
+
+	// BlockMaxSigOps is the most signature checks a block may require.
+	BlockMaxSigOps int
+}
+
+// BlockTemplate is a block ready to be mined. Only the nonce, and the
+// timestamp if the miner wants to, are left to fill in.
+type BlockTemplate struct {
+	Block blockchain.Block
+
+	// Fees is the total fee paid by the transactions of the block, which
+	// the coinbase collects on top of the subsidy.
+	Fees int
+
+	// Size is the length of the serialized block.
+	Size int
+
+	// SigOps is the number of signature checks the block requires.
+	SigOps int
+}
+
+// BlkTmplGenerator builds block templates from the transactions in a pool.
+type BlkTmplGenerator struct {
+	policy Policy
+	chain  *blockchain.BlockChain
+	txPool *mempool.TxPool
+}
+
+// NewBlkTmplGenerator returns a generator building blocks on top of the
+// active chain with the transactions in txPool.
+func NewBlkTmplGenerator(policy *Policy, chain *blockchain.BlockChain, txPool *mempool.TxPool) *BlkTmplGenerator {
+	p := *policy
+	if p.BlockMaxSize <= 0 || p.BlockMaxSize > chain.Params.MaxBlockSize {
+		p.BlockMaxSize = chain.Params.MaxBlockSize
+	}
+	if p.BlockMaxSigOps <= 0 || p.BlockMaxSigOps > chain.Params.MaxBlockSigOps {
+		p.BlockMaxSigOps = chain.Params.MaxBlockSigOps
+	}
+
+	return &BlkTmplGenerator{
+		policy: p,
+		chain:  chain,
+		txPool: txPool,
+	}
+}
+
+// NewBlockTemplate builds a block paying the subsidy and the fees to
+// payToAddress. Transactions are taken in the mining order of the pool, so
+// the ones with the highest ancestor fee rate come first and parents come
+// before their children, until the block is full. A transaction that does
+// not fit is skipped along with its descendants, and smaller ones are still
+// tried.
+func (g *BlkTmplGenerator) NewBlockTemplate(payToAddress string) (*BlockTemplate, error) {
+	header, err := g.chain.NextBlockHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	subsidy := blockchain.CalcBlockSubsidy(header.Height, g.chain.Params)
+
+	// size the block with the largest values its header and coinbase can
+	// hold, so the fees collected and the nonce found cannot push it over
+	// the limit
+	sizing := blockchain.Block{BlockHeader: header}
+	sizing.Nonce = math.MaxUint32
+	sizing.MerkleRoot = make([]byte, blockchain.HashLength)
+	sizing.Hash = make([]byte, blockchain.HashLength)
+	sizing.Transactions = []blockchain.Transaction{blockchain.CoinbaseTx(payToAddress, "", g.chain.Params.MaxSupply)}
+	blockSize := len(sizing.Serialize())
+	blockSigOps := 0
+
+	var txs []blockchain.Transaction
+	fees := 0
+	skipped := make(map[string]bool)
+
+	for _, desc := range g.txPool.MiningDescs() {
+		if dependsOnSkipped(&desc.Tx, skipped) {
+			skipped[hex.EncodeToString(desc.Tx.ID)] = true
+			continue
+		}
+
+		// one more byte for the separator between transactions
+		size := desc.Size + 1
+		sigOps := blockchain.CountSigOps(&desc.Tx)
+		if blockSize+size > g.policy.BlockMaxSize || blockSigOps+sigOps > g.policy.BlockMaxSigOps {
+			skipped[hex.EncodeToString(desc.Tx.ID)] = true
+			continue
+		}
+
+		blockSize += size
+		blockSigOps += sigOps
+		fees += desc.Fee
+		txs = append(txs, desc.Tx)
+	}
+
+	coinbase := blockchain.CoinbaseTx(payToAddress, "", subsidy+fees)
+	txs = append([]blockchain.Transaction{coinbase}, txs...)
+
+	block := blockchain.Block{BlockHeader: header, Transactions: txs}
+	block.MerkleRoot = block.HashTransactions()
+
+	template := &BlockTemplate{
+		Block:  block,
+		Fees:   fees,
+		Size:   len(block.Serialize()),
+		SigOps: blockSigOps,
+	}
+
+	return template, nil
+}
+
+// dependsOnSkipped reports whether tx spends an output of a transaction that
+// was left out of the block.
+func dependsOnSkipped(tx *blockchain.Transaction, skipped map[string]bool) bool {
+	for _, in := range tx.Inputs {
+		if skipped[hex.EncodeToString(in.ID)] {
+			return true
+		}
+	}
+
+	return false
+}
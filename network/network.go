@@ -1,471 +1,1286 @@
-package network
-
-import (
-	"bytes"
-	"encoding/gob"
-	"encoding/hex"
-	"fmt"
-	"io"
-	"net"
-	"os"
-	"runtime"
-	"syscall"
-
-	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
-	"github.com/dev-rodrigobaliza/go-blockchain/utils"
-	"github.com/vrecan/death/v3"
-)
-
-const (
-	protocol      = "tcp"
-	version       = 1
-	commandLength = 12
-)
-
-var (
-	nodeAddress     string
-	miningAddress   string
-	KnownNodes      = []string{"localhost:3000"}
-	blocksInTransit = [][]byte{}
-	memoryPool      = make(map[string]blockchain.Transaction)
-)
-
-type addr struct {
-	AddrList []string
-}
-
-type block struct {
-	AddrFrom string
-	Block    []byte
-}
-
-type getBlocks struct {
-	AddrFrom string
-}
-
-type getData struct {
-	AddrFrom string
-	Type     string
-	ID       []byte
-}
-
-type inv struct {
-	AddrFrom string
-	Type     string
-	Items    [][]byte
-}
-
-type tx struct {
-	AddrFrom    string
-	Transaction []byte
-}
-
-type Version struct {
-	Version    int
-	BestHeight int
-	AddrFrom   string
-}
-
-func StartServer(nodeID, minerAddress string) {
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
-	miningAddress = minerAddress
-
-	ln, err := net.Listen(protocol, nodeAddress)
-	utils.Handle(err)
-	defer ln.Close()
-
-	chain := blockchain.ContinueBlockChain(nodeID)
-	defer chain.Database.Close()
-	go closeDB(chain)
-
-	if nodeAddress != KnownNodes[0] {
-		sendVersion(KnownNodes[0], chain)
-	}
-
-	for {
-		conn, err := ln.Accept()
-		utils.Handle(err)
-
-		go handleConnection(conn, chain)
-	}
-}
-
-func serialize(cmd string) []byte {
-	var bytes [commandLength]byte
-
-	for i, c := range cmd {
-		bytes[i] = byte(c)
-	}
-
-	return bytes[:]
-}
-
-func deserialize(bytes []byte) string {
-	var cmd []byte
-
-	for _, b := range bytes {
-		if b != 0x00 {
-			cmd = append(cmd, b)
-		}
-	}
-
-	return string(cmd)
-}
-
-func requestBlocks() {
-	for _, node := range KnownNodes {
-		sendGetBlocks(node)
-	}
-}
-
-func closeDB(chain *blockchain.BlockChain) {
-	d := death.NewDeath(syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-
-	d.WaitForDeathWithFunc(func() {
-		defer os.Exit(1)
-		defer runtime.Goexit()
-		chain.Database.Close()
-	})
-}
-
-func gobEncode(data interface{}) []byte {
-	var buff bytes.Buffer
-
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(data)
-	utils.Handle(err)
-
-	return buff.Bytes()
-}
-
-func handleConnection(conn net.Conn, chain *blockchain.BlockChain) {
-	request, err := io.ReadAll(conn)
-	utils.Handle(err)
-	defer conn.Close()
-
-	command := deserialize(request[:commandLength])
-	fmt.Printf("Received %s command\n", command)
-
-	switch command {
-	case "addr":
-		handleAddr(request)
-
-	case "block":
-		handleBlock(request, chain)
-
-	case "inv":
-		handleInv(request, chain)
-
-	case "getblocks":
-		handleGetBlocks(request, chain)
-
-	case "getdata":
-		handleGetData(request, chain)
-
-	case "tx":
-		handleTx(request, chain)
-
-	case "version":
-		handleVersion(request, chain)
-
-	default:
-		fmt.Println("Unknown command!")
-	}
-}
-
-func handleAddr(request []byte) {
-	var buff bytes.Buffer
-	var payload addr
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.Handle(err)
-
-	KnownNodes = append(KnownNodes, payload.AddrList...)
-	fmt.Printf("There are %d known nodes now!\n", len(KnownNodes))
-	requestBlocks()
-}
-
-func handleBlock(request []byte, chain *blockchain.BlockChain) {
-	var buff bytes.Buffer
-	var payload block
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.Handle(err)
-
-	blockData := payload.Block
-	var block *blockchain.Block
-	err = block.Deserialize(blockData)
-	utils.Handle(err)
-
-	fmt.Println("Recevied a new block!")
-	chain.AddBlock(block)
-
-	fmt.Printf("Added block %x\n", block.Hash)
-
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
-
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := blockchain.UTXOSet{
-			Blockchain: chain,
-		}
-		UTXOSet.Reindex()
-	}
-}
-
-func handleInv(request []byte, chain *blockchain.BlockChain) {
-	var buff bytes.Buffer
-	var payload inv
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.Handle(err)
-
-	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
-
-	switch payload.Type {
-	case "block":
-		blocksInTransit = payload.Items
-
-		blockHash := payload.Items[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
-
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if !bytes.Equal(b, blockHash) {
-				newInTransit = append(newInTransit, b)
-			}
-		}
-		blocksInTransit = newInTransit
-
-	case "tx":
-		txID := payload.Items[0]
-
-		if memoryPool[hex.EncodeToString(txID)].ID == nil {
-			sendGetData(payload.AddrFrom, "tx", txID)
-		}
-	}
-}
-
-func handleGetBlocks(request []byte, chain *blockchain.BlockChain) {
-	var buff bytes.Buffer
-	var payload getBlocks
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.Handle(err)
-
-	blocks := chain.GetBlockHashes()
-	sendInv(payload.AddrFrom, "block", blocks)
-}
-
-func handleGetData(request []byte, chain *blockchain.BlockChain) {
-	var buff bytes.Buffer
-	var payload getData
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.Handle(err)
-
-	if payload.Type == "block" {
-		block, err := chain.GetBlock([]byte(payload.ID))
-		if err != nil {
-			return
-		}
-
-		sendBlock(payload.AddrFrom, block)
-	}
-
-	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := memoryPool[txID]
-
-		SendTx(payload.AddrFrom, tx)
-		// delete(mempool, txID)
-	}
-}
-
-func handleTx(request []byte, chain *blockchain.BlockChain) {
-	var buff bytes.Buffer
-	var payload tx
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.Handle(err)
-
-	txData := payload.Transaction
-	var tx blockchain.Transaction
-	err = tx.Deserialize(txData)
-	utils.Handle(err)
-
-	memoryPool[hex.EncodeToString(tx.ID)] = tx
-
-	fmt.Printf("%s, %d", nodeAddress, len(memoryPool))
-
-	if nodeAddress == KnownNodes[0] {
-		for _, node := range KnownNodes {
-			if node != nodeAddress && node != payload.AddrFrom {
-				sendInv(node, "tx", [][]byte{tx.ID})
-			}
-		}
-	} else {
-		if len(memoryPool) >= 2 && len(miningAddress) > 0 {
-			mineTx(chain)
-		}
-	}
-}
-
-func handleVersion(request []byte, chain *blockchain.BlockChain) {
-	var buff bytes.Buffer
-	var payload Version
-
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
-	utils.Handle(err)
-
-	myBestHeight := chain.GetBestHeight()
-	foreignerBestHeight := payload.BestHeight
-
-	if myBestHeight < foreignerBestHeight {
-		sendGetBlocks(payload.AddrFrom)
-	} else if myBestHeight > foreignerBestHeight {
-		sendVersion(payload.AddrFrom, chain)
-	}
-
-	if !nodeIsKnown(payload.AddrFrom) {
-		KnownNodes = append(KnownNodes, payload.AddrFrom)
-	}
-}
-
-func sendBlock(addr string, b *blockchain.Block) {
-	data := block{nodeAddress, b.Serialize()}
-	payload := gobEncode(data)
-	request := append(serialize("block"), payload...)
-
-	sendData(addr, request)
-}
-
-func sendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
-	if err != nil {
-		fmt.Printf("%s is not available\n", addr)
-		var updatedNodes []string
-
-		for _, node := range KnownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
-
-		KnownNodes = updatedNodes
-
-		return
-	}
-	defer conn.Close()
-
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	utils.Handle(err)
-}
-
-func sendGetBlocks(address string) {
-	payload := gobEncode(getBlocks{nodeAddress})
-	request := append(serialize("getblocks"), payload...)
-
-	sendData(address, request)
-}
-
-func sendGetData(address, kind string, id []byte) {
-	payload := gobEncode(getData{nodeAddress, kind, id})
-	request := append(serialize("getdata"), payload...)
-
-	sendData(address, request)
-}
-
-func sendInv(address, kind string, items [][]byte) {
-	inventory := inv{nodeAddress, kind, items}
-	payload := gobEncode(inventory)
-	request := append(serialize("inv"), payload...)
-
-	sendData(address, request)
-}
-
-func SendTx(addr string, tnx blockchain.Transaction) {
-	data := tx{nodeAddress, tnx.Serialize()}
-	payload := gobEncode(data)
-	request := append(serialize("tx"), payload...)
-
-	sendData(addr, request)
-}
-
-func sendVersion(addr string, bc *blockchain.BlockChain) {
-	bestHeight := bc.GetBestHeight()
-	payload := gobEncode(Version{version, bestHeight, nodeAddress})
-
-	request := append(serialize("version"), payload...)
-
-	sendData(addr, request)
-}
-
-func nodeIsKnown(addr string) bool {
-	for _, node := range KnownNodes {
-		if node == addr {
-			return true
-		}
-	}
-
-	return false
-}
-
-func mineTx(chain *blockchain.BlockChain) {
-	var txs []blockchain.Transaction
-
-	for id := range memoryPool {
-		fmt.Printf("tx: %s\n", memoryPool[id].ID)
-		tx := memoryPool[id]
-		if chain.VerifyTransaction(tx) {
-			txs = append(txs, tx)
-		}
-	}
-
-	if len(txs) == 0 {
-		fmt.Println("All transactions are invalid")
-		return
-	}
-
-	cbTx := blockchain.CoinbaseTx(miningAddress, "")
-	txs = append(txs, cbTx)
-
-	newBlock := chain.MineBlock(txs)
-	UTXOSet := blockchain.UTXOSet{
-		Blockchain: chain,
-	}
-	UTXOSet.Reindex()
-
-	fmt.Println("New block mined")
-
-	for _, tx := range txs {
-		txID := hex.EncodeToString(tx.ID)
-		delete(memoryPool, txID)
-	}
-
-	for _, node := range KnownNodes {
-		if node != nodeAddress {
-			sendInv(node, "block", [][]byte{newBlock.Hash})
-		}
-	}
-
-	if len(memoryPool) > 0 {
-		mineTx(chain)
-	}
-}
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+	"github.com/dev-rodrigobaliza/go-blockchain/fees"
+	"github.com/dev-rodrigobaliza/go-blockchain/mempool"
+	"github.com/dev-rodrigobaliza/go-blockchain/mining"
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+	"github.com/dev-rodrigobaliza/go-blockchain/stratum"
+	"github.com/dev-rodrigobaliza/go-blockchain/utils"
+	"github.com/dev-rodrigobaliza/go-blockchain/wallet"
+	"github.com/vrecan/death/v3"
+)
+
+const (
+	protocol      = "tcp"
+	version       = 1
+	commandLength = 12
+
+	// hashRateInterval is how often the hash rate is reported while mining.
+	hashRateInterval = 10 * time.Second
+)
+
+var (
+	nodeAddress    string
+	nodeChain      *blockchain.BlockChain
+	miningAddress  string
+	KnownNodes     []string
+	blockSync      = newSyncManager()
+	orphans        = newOrphanPool()
+	txPool         *mempool.TxPool
+	blockTemplates *mining.BlkTmplGenerator
+	cpuMiner       *mining.CPUMiner
+	stratumServer  *stratum.Server
+	feeEstimator   = fees.NewFeeEstimator(fees.DefaultMaxConfirms)
+
+	// miningMu makes sure a single mining loop runs at a time, and
+	// cancelJob abandons the block it is working on
+	miningMu  sync.Mutex
+	jobMu     sync.Mutex
+	cancelJob context.CancelFunc
+
+	// chainMu is held to change the active chain and update the pool to
+	// match, and read held to check transactions or build templates
+	// against both, which must never see the chain ahead of the pool
+	chainMu sync.RWMutex
+)
+
+type addr struct {
+	AddrList []string
+}
+
+type block struct {
+	AddrFrom string
+	Block    []byte
+}
+
+type estimateFee struct {
+	AddrFrom string
+	Blocks   int
+}
+
+type feeEstimate struct {
+	FeeRate int
+	Error   string
+}
+
+type getBlockTemplate struct {
+	AddrFrom   string
+	PayAddress string
+}
+
+type blockTemplate struct {
+	Block  []byte
+	Fees   int
+	Size   int
+	SigOps int
+	Error  string
+}
+
+type getBlocks struct {
+	AddrFrom string
+	Locator  [][]byte
+	StopHash []byte
+}
+
+type getMempoolTx struct {
+	AddrFrom string
+	ID       []byte
+}
+
+type mempoolTx struct {
+	Transaction []byte
+	Fee         int
+	Error       string
+}
+
+type getTxProof struct {
+	AddrFrom string
+	ID       []byte
+}
+
+type txProof struct {
+	Proof *blockchain.TxProof
+	Error string
+}
+
+type getHeaders struct {
+	AddrFrom string
+	Locator  [][]byte
+	StopHash []byte
+}
+
+type headers struct {
+	AddrFrom string
+	Headers  [][]byte
+}
+
+type getData struct {
+	AddrFrom string
+	Type     string
+	ID       []byte
+}
+
+type inv struct {
+	AddrFrom string
+	Type     string
+	Items    [][]byte
+}
+
+type tx struct {
+	AddrFrom    string
+	Transaction []byte
+}
+
+type Version struct {
+	Version    int
+	BestHeight int
+	AddrFrom   string
+}
+
+// UseChainParams sets the network the client calls of the package talk to.
+// StartServer does it for the node.
+func UseChainParams(chainParams *params.ChainParams) {
+	netMagic = chainParams.Magic
+}
+
+func StartServer(nodeID, minerAddress string, threads int, policy *mining.Policy, stratumAddress string, chainParams *params.ChainParams) {
+	UseChainParams(chainParams)
+	nodeAddress = chainParams.NodeAddress(nodeID)
+	miningAddress = minerAddress
+	KnownNodes = append([]string{}, chainParams.SeedNodes...)
+
+	ln, err := net.Listen(protocol, nodeAddress)
+	utils.Handle(err)
+	defer ln.Close()
+
+	chain := blockchain.ContinueBlockChain(nodeID, chainParams)
+	defer chain.Database.Close()
+	nodeChain = chain
+	go closeDB(chain)
+
+	txPool = mempool.New(&mempool.Config{
+		Chain:        chain,
+		FeeEstimator: feeEstimator,
+	})
+	blockTemplates = mining.NewBlkTmplGenerator(policy, chain, txPool)
+	cpuMiner = mining.NewCPUMiner(threads)
+
+	if stratumAddress != "" {
+		stratumServer = stratum.NewServer(&stratum.Config{
+			Generator: blockTemplates,
+			Params:    chainParams,
+			SubmitBlock: func(block *blockchain.Block) error {
+				return submitBlock(chain, block)
+			},
+		})
+
+		go func() {
+			err := stratumServer.ListenAndServe(stratumAddress)
+			utils.Handle(err)
+		}()
+		fmt.Printf("Mining server listening on %s\n", stratumAddress)
+	}
+
+	go blockSync.watchStalls()
+
+	if nodeAddress != KnownNodes[0] {
+		sendVersion(KnownNodes[0], chain)
+	}
+
+	for {
+		conn, err := ln.Accept()
+		utils.Handle(err)
+
+		go handleConnection(conn, chain)
+	}
+}
+
+func serialize(cmd string) []byte {
+	var bytes [commandLength]byte
+
+	for i, c := range cmd {
+		bytes[i] = byte(c)
+	}
+
+	return bytes[:]
+}
+
+func deserialize(bytes []byte) string {
+	var cmd []byte
+
+	for _, b := range bytes {
+		if b != 0x00 {
+			cmd = append(cmd, b)
+		}
+	}
+
+	return string(cmd)
+}
+
+func requestBlocks(chain *blockchain.BlockChain) {
+	for _, node := range KnownNodes {
+		sendGetBlocks(node, chain, nil)
+	}
+}
+
+func closeDB(chain *blockchain.BlockChain) {
+	d := death.NewDeath(syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+
+	d.WaitForDeathWithFunc(func() {
+		defer os.Exit(1)
+		defer runtime.Goexit()
+		chain.Database.Close()
+	})
+}
+
+func gobEncode(data interface{}) []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(data)
+	utils.Handle(err)
+
+	return buff.Bytes()
+}
+
+// handleConnection reads the messages of a peer until it closes the
+// connection. A message it cannot trust ends the connection, since what
+// follows can no longer be framed.
+func handleConnection(conn net.Conn, chain *blockchain.BlockChain) {
+	defer conn.Close()
+
+	for {
+		request, err := readMessage(conn)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			fmt.Printf("Dropped connection from %s: %s\n", conn.RemoteAddr(), err)
+			return
+		}
+
+		// messages are handled in the order the peer sent them
+		err = handleMessage(request, conn, chain)
+		if err != nil {
+			fmt.Printf("Dropped connection from %s: %s\n", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// handleMessage runs the handler of a message. It fails for a payload that
+// does not decode, which tells the connection to drop the peer.
+func handleMessage(request []byte, conn net.Conn, chain *blockchain.BlockChain) error {
+	command := deserialize(request[:commandLength])
+	fmt.Printf("Received %s command\n", command)
+
+	var err error
+
+	switch command {
+	case "addr":
+		err = handleAddr(request, chain)
+
+	case "block":
+		err = handleBlock(request, conn, chain)
+
+	case "estimatefee":
+		err = handleEstimateFee(request, conn)
+
+	case "inv":
+		err = handleInv(request, chain)
+
+	case "getblocks":
+		err = handleGetBlocks(request, chain)
+
+	case "getblocktmpl":
+		err = handleGetBlockTemplate(request, conn, chain)
+
+	case "getdata":
+		err = handleGetData(request, chain)
+
+	case "getheaders":
+		err = handleGetHeaders(request, chain)
+
+	case "headers":
+		err = handleHeaders(request, chain)
+
+	case "getmempooltx":
+		err = handleGetMempoolTx(request, conn)
+
+	case "gettxproof":
+		err = handleGetTxProof(request, conn, chain)
+
+	case "tx":
+		err = handleTx(request, chain)
+
+	case "version":
+		err = handleVersion(request, chain)
+
+	default:
+		fmt.Println("Unknown command!")
+	}
+
+	if err != nil {
+		return fmt.Errorf("malformed %s message: %w", command, err)
+	}
+
+	return nil
+}
+
+func handleAddr(request []byte, chain *blockchain.BlockChain) error {
+	var buff bytes.Buffer
+	var payload addr
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	KnownNodes = append(KnownNodes, payload.AddrList...)
+	fmt.Printf("There are %d known nodes now!\n", len(KnownNodes))
+	requestBlocks(chain)
+
+	return nil
+}
+
+func handleBlock(request []byte, conn net.Conn, chain *blockchain.BlockChain) error {
+	var buff bytes.Buffer
+	var payload block
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	blockData := payload.Block
+	block := &blockchain.Block{}
+	err = block.Deserialize(blockData)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Recevied a new block!")
+	processBlock(chain, block, conn)
+	blockSync.requestBlocks()
+
+	return nil
+}
+
+// processBlock adds a block received from a peer, then the blocks that were
+// waiting for it. Blocks requested by the sync can arrive before their
+// parent, from a faster peer, and are kept until it is added. Other blocks
+// with an unknown parent go to the orphan pool while their ancestors are
+// asked from the peer.
+func processBlock(chain *blockchain.BlockChain, block *blockchain.Block, conn net.Conn) {
+	requested := blockSync.blockReceived(block.Hash)
+	blocks := []*blockchain.Block{block}
+
+	for len(blocks) > 0 {
+		block := blocks[0]
+		blocks = blocks[1:]
+
+		tipMoved, err := connectBlock(chain, block)
+		if errors.Is(err, blockchain.ErrOrphanBlock) {
+			if requested {
+				fmt.Printf("Block %x is waiting for its parent\n", block.Hash)
+				blockSync.bufferBlock(block)
+			} else {
+				handleOrphan(chain, block, conn)
+			}
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Rejected block %x: %s\n", block.Hash, err)
+			discardDescendants(block.Hash)
+			continue
+		}
+
+		if tipMoved {
+			tipChanged()
+		}
+
+		fmt.Printf("Added block %x\n", block.Hash)
+
+		blocks = append(blocks, blockSync.takeChildren(block.Hash)...)
+		blocks = append(blocks, orphans.takeChildren(block.Hash)...)
+	}
+}
+
+// handleOrphan keeps a block whose parent is unknown and asks the peer that
+// sent it for the headers leading to it, which get the missing ancestors
+// downloaded. The peer is the other end of the connection, not the address
+// it claims, so it can neither dodge its orphan limit nor point the request
+// at someone else.
+func handleOrphan(chain *blockchain.BlockChain, block *blockchain.Block, conn net.Conn) {
+	peer := conn.RemoteAddr().String()
+
+	err := orphans.add(block, peer)
+	if err != nil {
+		fmt.Printf("Dropped orphan block %x: %s\n", block.Hash, err)
+		return
+	}
+
+	request, err := getHeadersRequest(chain, chain.Tip(), orphans.root(block.Hash))
+	if err != nil {
+		fmt.Printf("Failed to build a block locator: %s\n", err)
+		return
+	}
+
+	fmt.Printf("Orphan block %x, asking %s for its ancestors\n", block.Hash, peer)
+	err = writeMessage(conn, request)
+	if err != nil {
+		fmt.Printf("Failed to send to %s: %s\n", peer, err)
+	}
+}
+
+// discardDescendants drops the blocks waiting, directly or not, on a block
+// that was rejected.
+func discardDescendants(hash []byte) {
+	pending := [][]byte{hash}
+
+	for len(pending) > 0 {
+		children := blockSync.takeChildren(pending[0])
+		children = append(children, orphans.takeChildren(pending[0])...)
+
+		for _, child := range children {
+			fmt.Printf("Dropped block %x built on rejected block %x\n", child.Hash, child.PrevHash)
+			pending = append(pending, child.Hash)
+		}
+		pending = pending[1:]
+	}
+}
+
+func handleInv(request []byte, chain *blockchain.BlockChain) error {
+	var buff bytes.Buffer
+	var payload inv
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
+	if len(payload.Items) == 0 {
+		return nil
+	}
+
+	switch payload.Type {
+	case "block":
+		// blocks are only downloaded once their headers are validated, so
+		// ask for the headers of the new ones, which go on to the end of
+		// the peer chain. A full batch of known blocks is followed by the
+		// next one instead.
+		for _, hash := range payload.Items {
+			if !chain.HasBlock(hash) {
+				sendGetHeaders(payload.AddrFrom, chain, chain.Tip(), nil)
+				return nil
+			}
+		}
+
+		if len(payload.Items) == maxBlocksPerInv {
+			sendGetBlocks(payload.AddrFrom, chain, payload.Items[len(payload.Items)-1])
+		}
+
+	case "tx":
+		txID := payload.Items[0]
+
+		if !txPool.HaveTransaction(txID) {
+			sendGetData(payload.AddrFrom, "tx", txID)
+		}
+	}
+
+	return nil
+}
+
+func handleEstimateFee(request []byte, conn net.Conn) error {
+	var buff bytes.Buffer
+	var payload estimateFee
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	var reply feeEstimate
+	reply.FeeRate, err = feeEstimator.EstimateFee(payload.Blocks)
+	if err != nil {
+		reply.Error = err.Error()
+	}
+
+	response := append(serialize("feeestimate"), gobEncode(reply)...)
+	err = writeMessage(conn, response)
+	if err != nil {
+		fmt.Printf("Failed to reply to %s: %s\n", payload.AddrFrom, err)
+	}
+
+	return nil
+}
+
+func handleGetBlockTemplate(request []byte, conn net.Conn, chain *blockchain.BlockChain) error {
+	var buff bytes.Buffer
+	var payload getBlockTemplate
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	var reply blockTemplate
+	if wallet.ValidateAddress(payload.PayAddress, chain.Params) {
+		template, err := newBlockTemplate(payload.PayAddress)
+		if err == nil {
+			reply.Block = template.Block.Serialize()
+			reply.Fees = template.Fees
+			reply.Size = template.Size
+			reply.SigOps = template.SigOps
+		} else {
+			reply.Error = err.Error()
+		}
+	} else {
+		reply.Error = fmt.Sprintf("invalid pay address %q", payload.PayAddress)
+	}
+
+	response := append(serialize("blocktmpl"), gobEncode(reply)...)
+	err = writeMessage(conn, response)
+	if err != nil {
+		fmt.Printf("Failed to reply to %s: %s\n", payload.AddrFrom, err)
+	}
+
+	return nil
+}
+
+func handleGetMempoolTx(request []byte, conn net.Conn) error {
+	var buff bytes.Buffer
+	var payload getMempoolTx
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	var reply mempoolTx
+	desc, ok := txPool.FetchTxDesc(payload.ID)
+	if ok {
+		reply.Transaction = desc.Tx.Serialize()
+		reply.Fee = desc.Fee
+	} else {
+		reply.Error = fmt.Sprintf("transaction %x is not in the mempool", payload.ID)
+	}
+
+	response := append(serialize("mempooltx"), gobEncode(reply)...)
+	err = writeMessage(conn, response)
+	if err != nil {
+		fmt.Printf("Failed to reply to %s: %s\n", payload.AddrFrom, err)
+	}
+
+	return nil
+}
+
+func handleGetTxProof(request []byte, conn net.Conn, chain *blockchain.BlockChain) error {
+	var buff bytes.Buffer
+	var payload getTxProof
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	var reply txProof
+	proof, err := chain.GetMerkleProof(payload.ID)
+	if err != nil {
+		reply.Error = fmt.Sprintf("no proof for transaction %x: %s", payload.ID, err)
+	} else {
+		reply.Proof = proof
+	}
+
+	response := append(serialize("txproof"), gobEncode(reply)...)
+	err = writeMessage(conn, response)
+	if err != nil {
+		fmt.Printf("Failed to reply to %s: %s\n", payload.AddrFrom, err)
+	}
+
+	return nil
+}
+
+func handleGetBlocks(request []byte, chain *blockchain.BlockChain) error {
+	var buff bytes.Buffer
+	var payload getBlocks
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	blocks := chain.LocateBlocks(payload.Locator, payload.StopHash, maxBlocksPerInv)
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	sendInv(payload.AddrFrom, "block", blocks)
+
+	return nil
+}
+
+func handleGetHeaders(request []byte, chain *blockchain.BlockChain) error {
+	var buff bytes.Buffer
+	var payload getHeaders
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	found := chain.LocateHeaders(payload.Locator, payload.StopHash, maxHeadersPerMsg)
+	sendHeaders(payload.AddrFrom, found)
+
+	return nil
+}
+
+func handleHeaders(request []byte, chain *blockchain.BlockChain) error {
+	var buff bytes.Buffer
+	var payload headers
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Recevied %d headers\n", len(payload.Headers))
+	if len(payload.Headers) == 0 {
+		return nil
+	}
+
+	received := make([]blockchain.BlockHeader, len(payload.Headers))
+	for i, data := range payload.Headers {
+		err = received[i].Deserialize(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = chain.ProcessHeaders(received)
+	if err != nil {
+		fmt.Printf("Rejected headers from %s: %s\n", payload.AddrFrom, err)
+		return nil
+	}
+
+	last := &received[len(received)-1]
+	lastHash := last.BlockHash()
+	blockSync.updatePeer(payload.AddrFrom, last.Height)
+
+	var missing []blockRequest
+	for i := range received {
+		hash := received[i].BlockHash()
+		if !chain.HasBlock(hash) && !orphans.has(hash) {
+			missing = append(missing, blockRequest{hash, received[i].Height})
+		}
+	}
+	blockSync.queueBlocks(missing)
+
+	// a full batch means the peer may have more headers after it
+	if len(received) == maxHeadersPerMsg {
+		sendGetHeaders(payload.AddrFrom, chain, lastHash, nil)
+	}
+
+	blockSync.requestBlocks()
+
+	return nil
+}
+
+func handleGetData(request []byte, chain *blockchain.BlockChain) error {
+	var buff bytes.Buffer
+	var payload getData
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	if payload.Type == "block" {
+		block, err := chain.GetBlock([]byte(payload.ID))
+		if err != nil {
+			return nil
+		}
+
+		sendBlock(payload.AddrFrom, block)
+	}
+
+	if payload.Type == "tx" {
+		tx, ok := txPool.FetchTransaction(payload.ID)
+		if !ok {
+			return nil
+		}
+
+		SendTx(payload.AddrFrom, tx)
+	}
+
+	return nil
+}
+
+func handleTx(request []byte, chain *blockchain.BlockChain) error {
+	var buff bytes.Buffer
+	var payload tx
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	txData := payload.Transaction
+	var tx blockchain.Transaction
+	err = tx.Deserialize(txData)
+	if err != nil {
+		return err
+	}
+
+	err = acceptTransaction(&tx)
+	if err != nil {
+		fmt.Printf("Rejected tx %x: %s\n", tx.ID, err)
+		return nil
+	}
+
+	fmt.Printf("%s, %d\n", nodeAddress, txPool.Count())
+
+	if stratumServer != nil {
+		stratumServer.UpdateJobs(false)
+	}
+
+	if nodeAddress == KnownNodes[0] {
+		for _, node := range KnownNodes {
+			if node != nodeAddress && node != payload.AddrFrom {
+				sendInv(node, "tx", [][]byte{tx.ID})
+			}
+		}
+	} else {
+		if txPool.Count() >= 2 && len(miningAddress) > 0 {
+			go mineTx(chain)
+		}
+	}
+
+	return nil
+}
+
+func handleVersion(request []byte, chain *blockchain.BlockChain) error {
+	var buff bytes.Buffer
+	var payload Version
+
+	buff.Write(request[commandLength:])
+	dec := gob.NewDecoder(&buff)
+	err := dec.Decode(&payload)
+	if err != nil {
+		return err
+	}
+
+	myBestHeight := chain.GetBestHeight()
+	foreignerBestHeight := payload.BestHeight
+
+	if myBestHeight < foreignerBestHeight {
+		blockSync.updatePeer(payload.AddrFrom, foreignerBestHeight)
+		sendGetHeaders(payload.AddrFrom, chain, chain.Tip(), nil)
+	} else if myBestHeight > foreignerBestHeight {
+		sendVersion(payload.AddrFrom, chain)
+	}
+
+	if !nodeIsKnown(payload.AddrFrom) {
+		KnownNodes = append(KnownNodes, payload.AddrFrom)
+	}
+
+	return nil
+}
+
+func sendBlock(addr string, b *blockchain.Block) {
+	data := block{nodeAddress, b.Serialize()}
+	payload := gobEncode(data)
+	request := append(serialize("block"), payload...)
+
+	sendData(addr, request)
+}
+
+func sendData(addr string, data []byte) {
+	err := sendMessage(addr, data)
+	if err != nil {
+		fmt.Printf("%s is not available\n", addr)
+		var updatedNodes []string
+
+		for _, node := range KnownNodes {
+			if node != addr {
+				updatedNodes = append(updatedNodes, node)
+			}
+		}
+
+		KnownNodes = updatedNodes
+	}
+}
+
+// sendRequest sends a request to addr and waits for the reply on the same
+// connection.
+func sendRequest(addr string, data []byte) ([]byte, error) {
+	conn, err := net.Dial(protocol, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = writeMessage(conn, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return readMessage(conn)
+}
+
+// EstimateFee asks the node at addr for the fee rate, per 1000 bytes, that
+// gets a transaction confirmed within the given number of blocks.
+func EstimateFee(addr string, blocks int) (int, error) {
+	payload := gobEncode(estimateFee{nodeAddress, blocks})
+	request := append(serialize("estimatefee"), payload...)
+
+	response, err := sendRequest(addr, request)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(response) < commandLength || deserialize(response[:commandLength]) != "feeestimate" {
+		return 0, errors.New("unexpected reply to estimatefee")
+	}
+
+	var reply feeEstimate
+	dec := gob.NewDecoder(bytes.NewReader(response[commandLength:]))
+	err = dec.Decode(&reply)
+	if err != nil {
+		return 0, err
+	}
+
+	if reply.Error != "" {
+		return 0, errors.New(reply.Error)
+	}
+
+	return reply.FeeRate, nil
+}
+
+// GetBlockTemplate asks the node at addr for a block template paying its
+// coinbase to payAddress.
+func GetBlockTemplate(addr, payAddress string) (*mining.BlockTemplate, error) {
+	payload := gobEncode(getBlockTemplate{nodeAddress, payAddress})
+	request := append(serialize("getblocktmpl"), payload...)
+
+	response, err := sendRequest(addr, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response) < commandLength || deserialize(response[:commandLength]) != "blocktmpl" {
+		return nil, errors.New("unexpected reply to getblocktmpl")
+	}
+
+	var reply blockTemplate
+	dec := gob.NewDecoder(bytes.NewReader(response[commandLength:]))
+	err = dec.Decode(&reply)
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+
+	template := &mining.BlockTemplate{
+		Fees:   reply.Fees,
+		Size:   reply.Size,
+		SigOps: reply.SigOps,
+	}
+
+	err = template.Block.Deserialize(reply.Block)
+	if err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
+
+// GetMempoolTx asks the node at addr for an unconfirmed transaction of its
+// mempool and the fee it pays.
+func GetMempoolTx(addr string, txID []byte) (blockchain.Transaction, int, error) {
+	var tx blockchain.Transaction
+
+	payload := gobEncode(getMempoolTx{nodeAddress, txID})
+	request := append(serialize("getmempooltx"), payload...)
+
+	response, err := sendRequest(addr, request)
+	if err != nil {
+		return tx, 0, err
+	}
+
+	if len(response) < commandLength || deserialize(response[:commandLength]) != "mempooltx" {
+		return tx, 0, errors.New("unexpected reply to getmempooltx")
+	}
+
+	var reply mempoolTx
+	dec := gob.NewDecoder(bytes.NewReader(response[commandLength:]))
+	err = dec.Decode(&reply)
+	if err != nil {
+		return tx, 0, err
+	}
+
+	if reply.Error != "" {
+		return tx, 0, errors.New(reply.Error)
+	}
+
+	err = tx.Deserialize(reply.Transaction)
+	if err != nil {
+		return tx, 0, err
+	}
+
+	return tx, reply.Fee, nil
+}
+
+// sendGetBlocks asks a peer for the hashes of the blocks it has after our
+// tip. After is the last hash of a previous batch, which goes first in the
+// locator so the peer continues from there.
+func sendGetBlocks(address string, chain *blockchain.BlockChain, after []byte) {
+	locator, err := chain.BlockLocator(chain.Tip())
+	if err != nil {
+		fmt.Printf("Failed to build a block locator: %s\n", err)
+		return
+	}
+
+	if after != nil {
+		locator = append([][]byte{after}, locator...)
+	}
+
+	payload := gobEncode(getBlocks{nodeAddress, locator, nil})
+	request := append(serialize("getblocks"), payload...)
+
+	sendData(address, request)
+}
+
+// sendGetHeaders asks a peer for the headers after the branch ending at the
+// block with the given hash, up to the block with stopHash when given.
+func sendGetHeaders(address string, chain *blockchain.BlockChain, from, stopHash []byte) {
+	request, err := getHeadersRequest(chain, from, stopHash)
+	if err != nil {
+		fmt.Printf("Failed to build a block locator: %s\n", err)
+		return
+	}
+
+	sendData(address, request)
+}
+
+// getHeadersRequest builds a getheaders request for the headers after the
+// block locator of from, up to stopHash.
+func getHeadersRequest(chain *blockchain.BlockChain, from, stopHash []byte) ([]byte, error) {
+	locator, err := chain.BlockLocator(from)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := gobEncode(getHeaders{nodeAddress, locator, stopHash})
+
+	return append(serialize("getheaders"), payload...), nil
+}
+
+func sendHeaders(address string, found []blockchain.BlockHeader) {
+	data := headers{AddrFrom: nodeAddress}
+	for i := range found {
+		data.Headers = append(data.Headers, found[i].Serialize())
+	}
+
+	payload := gobEncode(data)
+	request := append(serialize("headers"), payload...)
+
+	sendData(address, request)
+}
+
+func sendGetData(address, kind string, id []byte) {
+	payload := gobEncode(getData{nodeAddress, kind, id})
+	request := append(serialize("getdata"), payload...)
+
+	sendData(address, request)
+}
+
+func sendInv(address, kind string, items [][]byte) {
+	inventory := inv{nodeAddress, kind, items}
+	payload := gobEncode(inventory)
+	request := append(serialize("inv"), payload...)
+
+	sendData(address, request)
+}
+
+func SendTx(addr string, tnx blockchain.Transaction) {
+	data := tx{nodeAddress, tnx.Serialize()}
+	payload := gobEncode(data)
+	request := append(serialize("tx"), payload...)
+
+	sendData(addr, request)
+}
+
+func sendVersion(addr string, bc *blockchain.BlockChain) {
+	bestHeight := bc.GetBestHeight()
+	payload := gobEncode(Version{version, bestHeight, nodeAddress})
+
+	request := append(serialize("version"), payload...)
+
+	sendData(addr, request)
+}
+
+func nodeIsKnown(addr string) bool {
+	for _, node := range KnownNodes {
+		if node == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mineTx mines blocks with the transactions of the pool until it is empty.
+// It returns right away when another mining loop is already running, which
+// will pick up the new transactions in its next block.
+func mineTx(chain *blockchain.BlockChain) {
+	if !miningMu.TryLock() {
+		return
+	}
+	defer miningMu.Unlock()
+
+	for txPool.Count() > 0 {
+		template, err := newBlockTemplate(miningAddress)
+		if err != nil {
+			fmt.Printf("Failed to build a block template: %s\n", err)
+			return
+		}
+
+		if len(template.Block.Transactions) == 1 {
+			fmt.Println("No transactions to mine")
+			return
+		}
+
+		for _, tx := range template.Block.Transactions[1:] {
+			fmt.Printf("tx: %x\n", tx.ID)
+		}
+
+		newBlock, err := solveBlock(template)
+		if errors.Is(err, mining.ErrMiningCancelled) {
+			fmt.Println("Mining job abandoned for the new tip")
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Failed to mine a block: %s\n", err)
+			return
+		}
+
+		fmt.Printf("Block solved at %.0f hashes/s on %d threads\n", cpuMiner.HashRate(), cpuMiner.Threads())
+
+		err = submitBlock(chain, &newBlock)
+		if err != nil {
+			fmt.Printf("Mined block rejected: %s\n", err)
+			return
+		}
+
+		fmt.Println("New block mined")
+	}
+}
+
+// submitBlock adds a block mined by this node, or by one of the workers of
+// its mining server, and announces it to the other nodes.
+func submitBlock(chain *blockchain.BlockChain, block *blockchain.Block) error {
+	tipMoved, err := connectBlock(chain, block)
+	if err != nil {
+		return err
+	}
+
+	if tipMoved {
+		tipChanged()
+	}
+
+	for _, node := range KnownNodes {
+		if node != nodeAddress {
+			sendInv(node, "block", [][]byte{block.Hash})
+		}
+	}
+
+	return nil
+}
+
+// tipChanged abandons the work built on the previous tip of the chain.
+func tipChanged() {
+	abandonMiningJob()
+
+	if stratumServer != nil {
+		stratumServer.UpdateJobs(true)
+	}
+}
+
+// solveBlock mines template as the current job, reporting the hash rate
+// until it is solved or abandoned.
+func solveBlock(template *mining.BlockTemplate) (blockchain.Block, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobMu.Lock()
+	cancelJob = cancel
+	jobMu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(hashRateInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fmt.Printf("Mining block %d at %.0f hashes/s\n", template.Block.Height, cpuMiner.HashRate())
+			}
+		}
+	}()
+
+	block, err := cpuMiner.Solve(ctx, template)
+
+	jobMu.Lock()
+	cancelJob = nil
+	jobMu.Unlock()
+
+	return block, err
+}
+
+// abandonMiningJob stops the block being mined, if any.
+func abandonMiningJob() {
+	jobMu.Lock()
+	defer jobMu.Unlock()
+
+	if cancelJob != nil {
+		cancelJob()
+		cancelJob = nil
+	}
+}
+
+// updateMemoryPool puts back the transactions of blocks that left the active
+// chain and drops the ones that are now confirmed, feeding the confirmations
+// to the fee estimator. The disconnected blocks come from the old tip down,
+// so they are put back oldest first.
+// connectBlock adds a block to the chain and updates the pool for the blocks
+// that left and joined the active chain, as one step for the transactions
+// checked against them. It reports whether the tip moved.
+func connectBlock(chain *blockchain.BlockChain, block *blockchain.Block) (bool, error) {
+	chainMu.Lock()
+	defer chainMu.Unlock()
+
+	disconnected, connected, err := chain.AddBlock(block)
+	if err != nil {
+		return false, err
+	}
+
+	updateMemoryPool(disconnected, connected)
+
+	return len(connected) > 0, nil
+}
+
+// acceptTransaction adds a transaction to the pool, checked against a chain
+// the pool is up to date with.
+func acceptTransaction(tx *blockchain.Transaction) error {
+	chainMu.RLock()
+	defer chainMu.RUnlock()
+
+	_, err := txPool.MaybeAcceptTransaction(tx)
+
+	return err
+}
+
+// newBlockTemplate builds a block template from a chain and a pool that
+// agree with each other.
+func newBlockTemplate(payToAddress string) (*mining.BlockTemplate, error) {
+	chainMu.RLock()
+	defer chainMu.RUnlock()
+
+	return blockTemplates.NewBlockTemplate(payToAddress)
+}
+
+func updateMemoryPool(disconnected, connected []blockchain.Block) {
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		txPool.BlockDisconnected(&disconnected[i])
+	}
+
+	for _, block := range connected {
+		var txIDs []string
+
+		txPool.BlockConnected(&block)
+		for _, tx := range block.Transactions {
+			txIDs = append(txIDs, hex.EncodeToString(tx.ID))
+		}
+
+		feeEstimator.RegisterBlock(block.Height, txIDs)
+	}
+}
+
+// GetTxProof asks the node at addr for the merkle proof that a confirmed
+// transaction is included in its block. The proof is not verified.
+func GetTxProof(addr string, txID []byte) (*blockchain.TxProof, error) {
+	payload := gobEncode(getTxProof{nodeAddress, txID})
+	request := append(serialize("gettxproof"), payload...)
+
+	response, err := sendRequest(addr, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response) < commandLength || deserialize(response[:commandLength]) != "txproof" {
+		return nil, errors.New("unexpected reply to gettxproof")
+	}
+
+	var reply txProof
+	dec := gob.NewDecoder(bytes.NewReader(response[commandLength:]))
+	err = dec.Decode(&reply)
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+
+	if reply.Proof == nil {
+		return nil, errors.New("empty reply to gettxproof")
+	}
+
+	return reply.Proof, nil
+}
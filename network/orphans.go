@@ -0,0 +1,190 @@
+package network
+
+import (
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+)
+
+const (
+	// maxOrphanBlocks is the most orphan blocks kept in memory. The oldest
+	// is evicted to make room for a new one.
+	maxOrphanBlocks = 100
+
+	// maxOrphansPerPeer is the most orphan blocks kept from a single peer,
+	// so one peer cannot fill the pool.
+	maxOrphansPerPeer = 20
+
+	// orphanExpiry is how long an orphan block waits for its parent.
+	orphanExpiry = 10 * time.Minute
+)
+
+var errTooManyOrphans = errors.New("peer has too many orphan blocks")
+
+type orphanBlock struct {
+	block      *blockchain.Block
+	peer       string
+	expiration time.Time
+}
+
+// orphanPool keeps blocks received out of the blue whose parent is not
+// known yet, until their ancestors are downloaded from the peer that sent
+// them.
+type orphanPool struct {
+	mu       sync.Mutex
+	orphans  map[string]*orphanBlock
+	byParent map[string][]*orphanBlock
+	perPeer  map[string]int
+}
+
+func newOrphanPool() *orphanPool {
+	return &orphanPool{
+		orphans:  make(map[string]*orphanBlock),
+		byParent: make(map[string][]*orphanBlock),
+		perPeer:  make(map[string]int),
+	}
+}
+
+// add keeps an orphan block sent by peer. It fails when the peer already
+// has too many orphans in the pool.
+func (p *orphanPool) add(block *blockchain.Block, peer string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := hex.EncodeToString(block.Hash)
+	if _, ok := p.orphans[key]; ok {
+		return nil
+	}
+
+	p.expire()
+
+	if p.perPeer[peer] >= maxOrphansPerPeer {
+		return errTooManyOrphans
+	}
+
+	if len(p.orphans) >= maxOrphanBlocks {
+		p.evictOldest()
+	}
+
+	orphan := &orphanBlock{
+		block:      block,
+		peer:       peer,
+		expiration: time.Now().Add(orphanExpiry),
+	}
+
+	parentKey := hex.EncodeToString(block.PrevHash)
+	p.orphans[key] = orphan
+	p.byParent[parentKey] = append(p.byParent[parentKey], orphan)
+	p.perPeer[peer]++
+
+	return nil
+}
+
+// has reports whether the block with the given hash is in the pool.
+func (p *orphanPool) has(hash []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.orphans[hex.EncodeToString(hash)]
+
+	return ok
+}
+
+// root returns the hash of the oldest orphan the block with the given hash
+// descends from, which is the first block whose parent is missing.
+func (p *orphanPool) root(hash []byte) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		orphan, ok := p.orphans[hex.EncodeToString(hash)]
+		if !ok {
+			return hash
+		}
+
+		parent, ok := p.orphans[hex.EncodeToString(orphan.block.PrevHash)]
+		if !ok {
+			return hash
+		}
+
+		hash = parent.block.Hash
+	}
+}
+
+// takeChildren removes and returns the orphans built on the block with the
+// given hash.
+func (p *orphanPool) takeChildren(hash []byte) []*blockchain.Block {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := hex.EncodeToString(hash)
+	siblings := p.byParent[key]
+	delete(p.byParent, key)
+
+	var children []*blockchain.Block
+	for _, orphan := range siblings {
+		children = append(children, orphan.block)
+		p.forget(orphan)
+	}
+
+	return children
+}
+
+// expire removes the orphans whose parent did not show up in time.
+func (p *orphanPool) expire() {
+	now := time.Now()
+
+	for _, orphan := range p.orphans {
+		if now.After(orphan.expiration) {
+			p.remove(orphan)
+		}
+	}
+}
+
+func (p *orphanPool) evictOldest() {
+	var oldest *orphanBlock
+
+	for _, orphan := range p.orphans {
+		if oldest == nil || orphan.expiration.Before(oldest.expiration) {
+			oldest = orphan
+		}
+	}
+
+	if oldest != nil {
+		p.remove(oldest)
+	}
+}
+
+// remove takes an orphan out of the pool.
+func (p *orphanPool) remove(orphan *orphanBlock) {
+	parentKey := hex.EncodeToString(orphan.block.PrevHash)
+
+	var siblings []*orphanBlock
+	for _, sibling := range p.byParent[parentKey] {
+		if sibling != orphan {
+			siblings = append(siblings, sibling)
+		}
+	}
+
+	if len(siblings) == 0 {
+		delete(p.byParent, parentKey)
+	} else {
+		p.byParent[parentKey] = siblings
+	}
+
+	p.forget(orphan)
+}
+
+// forget drops an orphan from the pool and from the count of its peer. The
+// caller takes care of the list of its parent.
+func (p *orphanPool) forget(orphan *orphanBlock) {
+	delete(p.orphans, hex.EncodeToString(orphan.block.Hash))
+
+	p.perPeer[orphan.peer]--
+	if p.perPeer[orphan.peer] <= 0 {
+		delete(p.perPeer, orphan.peer)
+	}
+}
@@ -0,0 +1,79 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+)
+
+func newOrphan(hash, parent byte) *blockchain.Block {
+	return &blockchain.Block{
+		BlockHeader: blockchain.BlockHeader{PrevHash: []byte{parent}},
+		Hash:        []byte{hash},
+	}
+}
+
+func TestOrphanPoolTakeSiblings(t *testing.T) {
+	pool := newOrphanPool()
+	siblings := []*blockchain.Block{newOrphan(1, 0), newOrphan(2, 0), newOrphan(3, 0), newOrphan(4, 0)}
+
+	for i, block := range siblings {
+		// two peers, so the per peer counts are checked too
+		peer := "a"
+		if i%2 == 1 {
+			peer = "b"
+		}
+
+		err := pool.add(block, peer)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := pool.add(newOrphan(5, 1), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	children := pool.takeChildren([]byte{0})
+	if len(children) != len(siblings) {
+		t.Fatalf("took %d children, want %d", len(children), len(siblings))
+	}
+	for i, child := range children {
+		if !bytes.Equal(child.Hash, siblings[i].Hash) {
+			t.Fatalf("child %d is %x, want %x", i, child.Hash, siblings[i].Hash)
+		}
+	}
+
+	for _, block := range siblings {
+		if pool.has(block.Hash) {
+			t.Fatalf("block %x is still in the pool", block.Hash)
+		}
+	}
+
+	if len(pool.orphans) != 1 || pool.perPeer["a"] != 1 || pool.perPeer["b"] != 0 {
+		t.Fatalf("pool keeps %d orphans with peer counts %v, want 1 orphan of peer a", len(pool.orphans), pool.perPeer)
+	}
+
+	grandchildren := pool.takeChildren([]byte{1})
+	if len(grandchildren) != 1 || len(pool.orphans) != 0 || len(pool.byParent) != 0 || len(pool.perPeer) != 0 {
+		t.Fatalf("pool is not empty after taking every orphan")
+	}
+}
+
+func TestOrphanPoolRemoveSibling(t *testing.T) {
+	pool := newOrphanPool()
+	for hash := byte(1); hash <= 3; hash++ {
+		err := pool.add(newOrphan(hash, 0), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pool.remove(pool.orphans["02"])
+
+	children := pool.takeChildren([]byte{0})
+	if len(children) != 2 || children[0].Hash[0] != 1 || children[1].Hash[0] != 3 {
+		t.Fatalf("took the wrong children after removing a sibling")
+	}
+}
@@ -0,0 +1,281 @@
+package network
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+)
+
+const (
+	// maxHeadersPerMsg is the most headers sent in reply to getheaders. A
+	// full batch tells the requester there may be more.
+	maxHeadersPerMsg = 2000
+
+	// maxBlocksPerInv is the most block hashes sent in reply to getblocks.
+	// A full batch tells the requester to ask for the next one.
+	maxBlocksPerInv = 500
+
+	// maxBlocksInFlightPerPeer is the most blocks requested from a single
+	// peer and not received yet.
+	maxBlocksInFlightPerPeer = 16
+
+	// maxBlocksBuffered bounds the downloaded blocks kept while their parent
+	// is still on its way from a slower peer.
+	maxBlocksBuffered = 256
+
+	// blockRequestTimeout is how long a peer has to deliver a requested
+	// block before the request goes to another peer.
+	blockRequestTimeout = 30 * time.Second
+
+	// stallCheckInterval is how often requests are checked for timeouts.
+	stallCheckInterval = 5 * time.Second
+)
+
+type blockRequest struct {
+	hash   []byte
+	height int
+}
+
+type inFlightBlock struct {
+	blockRequest
+	peer string
+	sent time.Time
+}
+
+type syncPeer struct {
+	addr     string
+	height   int
+	inFlight int
+}
+
+// syncManager downloads the blocks whose headers were accepted. Requests
+// are spread over the peers whose chain reaches the block, with a limit of
+// blocks in flight per peer, and go to another peer when one does not
+// deliver in time.
+type syncManager struct {
+	mu       sync.Mutex
+	peers    map[string]*syncPeer
+	queue    []blockRequest
+	queued   map[string]bool
+	inFlight map[string]*inFlightBlock
+
+	// buffered holds downloaded blocks by the hash of their parent, and
+	// bufferedHashes the hashes of those blocks.
+	buffered       map[string][]*blockchain.Block
+	bufferedHashes map[string]bool
+}
+
+func newSyncManager() *syncManager {
+	return &syncManager{
+		peers:          make(map[string]*syncPeer),
+		queued:         make(map[string]bool),
+		inFlight:       make(map[string]*inFlightBlock),
+		buffered:       make(map[string][]*blockchain.Block),
+		bufferedHashes: make(map[string]bool),
+	}
+}
+
+// updatePeer records that the chain of a peer reaches at least the given
+// height.
+func (m *syncManager) updatePeer(addr string, height int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peer, ok := m.peers[addr]
+	if !ok {
+		peer = &syncPeer{addr: addr}
+		m.peers[addr] = peer
+	}
+
+	if height > peer.height {
+		peer.height = height
+	}
+}
+
+// queueBlocks adds the blocks to download, skipping the ones already
+// waiting, requested or buffered.
+func (m *syncManager) queueBlocks(requests []blockRequest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, request := range requests {
+		key := hex.EncodeToString(request.hash)
+		if m.queued[key] || m.inFlight[key] != nil || m.bufferedHashes[key] {
+			continue
+		}
+
+		m.queue = append(m.queue, request)
+		m.queued[key] = true
+	}
+
+	m.sortQueue()
+}
+
+// sortQueue keeps the lowest blocks first, so the chain can be connected as
+// they arrive.
+func (m *syncManager) sortQueue() {
+	sort.SliceStable(m.queue, func(i, j int) bool {
+		return m.queue[i].height < m.queue[j].height
+	})
+}
+
+// requestBlocks sends as many queued requests as the peers have room for.
+func (m *syncManager) requestBlocks() {
+	type getDataRequest struct {
+		peer string
+		hash []byte
+	}
+
+	var requests []getDataRequest
+
+	m.mu.Lock()
+	var remaining []blockRequest
+	for _, request := range m.queue {
+		if len(m.inFlight)+len(m.bufferedHashes) >= maxBlocksBuffered {
+			remaining = append(remaining, request)
+			continue
+		}
+
+		peer := m.pickPeer(request.height)
+		if peer == nil {
+			remaining = append(remaining, request)
+			continue
+		}
+
+		key := hex.EncodeToString(request.hash)
+		delete(m.queued, key)
+		m.inFlight[key] = &inFlightBlock{request, peer.addr, time.Now()}
+		peer.inFlight++
+
+		requests = append(requests, getDataRequest{peer.addr, request.hash})
+	}
+	m.queue = remaining
+	m.mu.Unlock()
+
+	for _, request := range requests {
+		sendGetData(request.peer, "block", request.hash)
+	}
+}
+
+// pickPeer returns the least busy peer that has the block at the given
+// height and room for one more request.
+func (m *syncManager) pickPeer(height int) *syncPeer {
+	var best *syncPeer
+
+	for _, peer := range m.peers {
+		if peer.height < height || peer.inFlight >= maxBlocksInFlightPerPeer {
+			continue
+		}
+
+		if best == nil || peer.inFlight < best.inFlight {
+			best = peer
+		}
+	}
+
+	return best
+}
+
+// blockReceived clears the request for a block and reports whether the
+// block had been requested.
+func (m *syncManager) blockReceived(hash []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := hex.EncodeToString(hash)
+	request, ok := m.inFlight[key]
+	if !ok {
+		return false
+	}
+
+	delete(m.inFlight, key)
+	if peer, ok := m.peers[request.peer]; ok {
+		peer.inFlight--
+	}
+
+	return true
+}
+
+// bufferBlock keeps a downloaded block until its parent is added.
+func (m *syncManager) bufferBlock(block *blockchain.Block) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := hex.EncodeToString(block.Hash)
+	if m.bufferedHashes[key] {
+		return
+	}
+
+	parentKey := hex.EncodeToString(block.PrevHash)
+	m.buffered[parentKey] = append(m.buffered[parentKey], block)
+	m.bufferedHashes[key] = true
+}
+
+// takeChildren removes and returns the buffered blocks built on the block
+// with the given hash.
+func (m *syncManager) takeChildren(hash []byte) []*blockchain.Block {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := hex.EncodeToString(hash)
+	children := m.buffered[key]
+	delete(m.buffered, key)
+
+	for _, child := range children {
+		delete(m.bufferedHashes, hex.EncodeToString(child.Hash))
+	}
+
+	return children
+}
+
+// checkStalls drops the peers with a request that timed out from the
+// download, until they show their chain again, and queues all their
+// requests for the other peers.
+func (m *syncManager) checkStalls() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stalled := make(map[string]bool)
+	now := time.Now()
+	for _, request := range m.inFlight {
+		if now.Sub(request.sent) >= blockRequestTimeout && !stalled[request.peer] {
+			fmt.Printf("Peer %s stalled on block %x, dropping it from the download\n", request.peer, request.hash)
+			stalled[request.peer] = true
+		}
+	}
+
+	if len(stalled) == 0 {
+		return
+	}
+
+	for key, request := range m.inFlight {
+		if !stalled[request.peer] {
+			continue
+		}
+
+		delete(m.inFlight, key)
+		m.queue = append(m.queue, request.blockRequest)
+		m.queued[key] = true
+	}
+
+	for peer := range stalled {
+		delete(m.peers, peer)
+	}
+
+	m.sortQueue()
+}
+
+// watchStalls moves the requests of stalled peers to other peers until the
+// node stops.
+func (m *syncManager) watchStalls() {
+	ticker := time.NewTicker(stallCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.checkStalls()
+		m.requestBlocks()
+	}
+}
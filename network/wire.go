@@ -0,0 +1,203 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// messageHeaderSize is the length of the envelope in front of every
+	// payload: the network magic, the command, the payload length and the
+	// payload checksum.
+	messageHeaderSize = 4 + commandLength + 4 + 4
+
+	// maxMessagePayload is the largest payload accepted, with room for the
+	// largest block and its encoding.
+	maxMessagePayload = 4 * 1024 * 1024
+
+	// writeTimeout bounds the time a message takes to be written, so a peer
+	// that stopped reading cannot block the node.
+	writeTimeout = 30 * time.Second
+)
+
+var (
+	errBadMagic        = errors.New("message is for another network")
+	errMessageTooBig   = errors.New("message payload is too big")
+	errBadChecksum     = errors.New("message payload does not match its checksum")
+	errMessageTooShort = errors.New("message has no command")
+)
+
+var (
+	// netMagic is the magic of the network the node talks to.
+	netMagic uint32
+
+	// peerConns holds the connections messages are sent on, one per peer,
+	// kept open between messages.
+	peerConns   = make(map[string]net.Conn)
+	peerConnsMu sync.Mutex
+)
+
+// checksum returns the first four bytes of the double SHA-256 of payload.
+func checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	return second[:4]
+}
+
+// encodeMessage wraps a request, a command followed by its payload, in its
+// envelope.
+func encodeMessage(request []byte) ([]byte, error) {
+	if len(request) < commandLength {
+		return nil, errMessageTooShort
+	}
+
+	payload := request[commandLength:]
+	if len(payload) > maxMessagePayload {
+		return nil, errMessageTooBig
+	}
+
+	message := make([]byte, messageHeaderSize, messageHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(message[0:], netMagic)
+	copy(message[4:], request[:commandLength])
+	binary.BigEndian.PutUint32(message[4+commandLength:], uint32(len(payload)))
+	copy(message[8+commandLength:], checksum(payload))
+	message = append(message, payload...)
+
+	return message, nil
+}
+
+// writeMessage writes a request in its envelope. The message goes out in a
+// single write, so several goroutines can share the connection.
+func writeMessage(w io.Writer, request []byte) error {
+	message, err := encodeMessage(request)
+	if err != nil {
+		return err
+	}
+
+	if conn, ok := w.(net.Conn); ok {
+		err = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write(message)
+
+	return err
+}
+
+// readMessage reads the next message and returns it as a request, its
+// command followed by its payload. A message for another network, too big or
+// corrupted is an error, after which the stream can no longer be trusted.
+func readMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, messageHeaderSize)
+
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(header[0:]) != netMagic {
+		return nil, errBadMagic
+	}
+
+	length := binary.BigEndian.Uint32(header[4+commandLength:])
+	if length > maxMessagePayload {
+		return nil, errMessageTooBig
+	}
+
+	request := make([]byte, commandLength+int(length))
+	copy(request, header[4:4+commandLength])
+
+	_, err = io.ReadFull(r, request[commandLength:])
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(checksum(request[commandLength:]), header[8+commandLength:]) {
+		return nil, errBadChecksum
+	}
+
+	return request, nil
+}
+
+// peerConn returns the open connection to addr, dialing it when there is
+// none.
+func peerConn(addr string) (net.Conn, error) {
+	peerConnsMu.Lock()
+	defer peerConnsMu.Unlock()
+
+	if conn, ok := peerConns[addr]; ok {
+		return conn, nil
+	}
+
+	conn, err := net.Dial(protocol, addr)
+	if err != nil {
+		return nil, err
+	}
+	peerConns[addr] = conn
+
+	// peers answer on the connections we open, like the getheaders asking
+	// for the ancestors of an orphan block, so a node reads them as the
+	// ones opened by its peers
+	go func() {
+		if nodeChain != nil {
+			handleConnection(conn, nodeChain)
+		} else {
+			_, _ = io.Copy(io.Discard, conn)
+		}
+		closePeerConn(addr, conn)
+	}()
+
+	return conn, nil
+}
+
+// closePeerConn closes the connection to addr, unless it was already
+// replaced by a new one.
+func closePeerConn(addr string, conn net.Conn) {
+	peerConnsMu.Lock()
+	defer peerConnsMu.Unlock()
+
+	if peerConns[addr] == conn {
+		delete(peerConns, addr)
+	}
+	conn.Close()
+}
+
+// sendMessage sends a request to addr on the connection kept for it. A
+// connection the peer closed since the last message is dialed again once.
+func sendMessage(addr string, request []byte) error {
+	message, err := encodeMessage(request)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		var conn net.Conn
+
+		conn, err = peerConn(addr)
+		if err != nil {
+			return err
+		}
+
+		err = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if err == nil {
+			_, err = conn.Write(message)
+		}
+		if err == nil {
+			return nil
+		}
+
+		closePeerConn(addr, conn)
+	}
+
+	return fmt.Errorf("failed to send to %s: %w", addr, err)
+}
@@ -0,0 +1,148 @@
+package params
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// ChainParams bundles the values that define a network. Nodes only talk to
+// and store data for the network of their params, so several networks can
+// run side by side from the same binary.
+type ChainParams struct {
+	// Name identifies the network and names the directory of its data.
+	Name string
+
+	// Magic starts every message on the wire, so nodes drop the messages
+	// of other networks.
+	Magic uint32
+
+	// PortOffset is added to the node ID to get the port a node listens on.
+	PortOffset int
+
+	// SeedNodes are the addresses of the nodes contacted on start up.
+	SeedNodes []string
+
+	// AddressVersion is the version byte prefixed to wallet addresses.
+	AddressVersion byte
+
+	// GenesisMessage is the data of the genesis coinbase input.
+	GenesisMessage string
+
+	// CoinbaseReward is the subsidy a coinbase may mint on top of the fees
+	// before the first halving.
+	CoinbaseReward int
+
+	// SubsidyHalvingInterval is the number of blocks after which the
+	// subsidy halves.
+	SubsidyHalvingInterval int
+
+	// CoinbaseMaturity is the number of blocks a coinbase output must wait
+	// before it can be spent. A coinbase mined at height h may be spent by
+	// a block at height h+CoinbaseMaturity or above.
+	CoinbaseMaturity int
+
+	// MaxSupply is the most coins that will ever be minted.
+	MaxSupply int
+
+	// PowLimit is the easiest target a block may use. Genesis uses it.
+	PowLimit *big.Int
+
+	// TargetBlockInterval is the time the network aims to spend on a block.
+	TargetBlockInterval time.Duration
+
+	// RetargetWindow is the number of blocks between two difficulty
+	// adjustments.
+	RetargetWindow int
+
+	// NoRetargeting keeps every block at PowLimit.
+	NoRetargeting bool
+
+	// MaxBlockSize is the largest serialized block allowed, in bytes.
+	MaxBlockSize int
+
+	// MaxBlockSigOps is the most signature checks a block may require.
+	MaxBlockSigOps int
+}
+
+// MainNet is the main network.
+var MainNet = ChainParams{
+	Name:                   "mainnet",
+	Magic:                  0xd9b4bef9,
+	PortOffset:             0,
+	SeedNodes:              []string{"localhost:3000"},
+	AddressVersion:         0x00,
+	GenesisMessage:         "First Transaction from Genesis",
+	CoinbaseReward:         20,
+	SubsidyHalvingInterval: 210000,
+	CoinbaseMaturity:       100,
+	MaxSupply:              7500000,
+	PowLimit:               new(big.Int).Lsh(big.NewInt(1), 256-14),
+	TargetBlockInterval:    time.Minute,
+	RetargetWindow:         20,
+	MaxBlockSize:           1000000,
+	MaxBlockSigOps:         20000,
+}
+
+// TestNet is the public test network, with an easier difficulty and faster
+// blocks than MainNet.
+var TestNet = ChainParams{
+	Name:                   "testnet",
+	Magic:                  0x0709110b,
+	PortOffset:             10000,
+	SeedNodes:              []string{"localhost:13000"},
+	AddressVersion:         0x6f,
+	GenesisMessage:         "First Transaction from TestNet Genesis",
+	CoinbaseReward:         20,
+	SubsidyHalvingInterval: 210000,
+	CoinbaseMaturity:       100,
+	MaxSupply:              7500000,
+	PowLimit:               new(big.Int).Lsh(big.NewInt(1), 256-12),
+	TargetBlockInterval:    10 * time.Second,
+	RetargetWindow:         20,
+	MaxBlockSize:           1000000,
+	MaxBlockSigOps:         20000,
+}
+
+// RegTest is the regression test network. Its difficulty is trivial and
+// never changes, so blocks can be produced on demand.
+var RegTest = ChainParams{
+	Name:                   "regtest",
+	Magic:                  0xdab5bffa,
+	PortOffset:             20000,
+	SeedNodes:              []string{"localhost:23000"},
+	AddressVersion:         0x6f,
+	GenesisMessage:         "First Transaction from RegTest Genesis",
+	CoinbaseReward:         20,
+	SubsidyHalvingInterval: 150,
+	CoinbaseMaturity:       1,
+	MaxSupply:              5000,
+	PowLimit:               new(big.Int).Lsh(big.NewInt(1), 255),
+	TargetBlockInterval:    time.Second,
+	RetargetWindow:         20,
+	NoRetargeting:          true,
+	MaxBlockSize:           1000000,
+	MaxBlockSigOps:         20000,
+}
+
+// ByName returns the params of the named network.
+func ByName(name string) (*ChainParams, error) {
+	for _, p := range []*ChainParams{&MainNet, &TestNet, &RegTest} {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown network %q", name)
+}
+
+// NodeAddress returns the address a node with the given ID listens on.
+func (p *ChainParams) NodeAddress(nodeID string) string {
+	port, err := strconv.Atoi(nodeID)
+	if err != nil {
+		return fmt.Sprintf("localhost:%s", nodeID)
+	}
+
+	return fmt.Sprintf("localhost:%d", port+p.PortOffset)
+}
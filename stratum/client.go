@@ -0,0 +1,176 @@
+package stratum
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/goccy/go-json"
+)
+
+// ErrClosed is returned by requests still waiting when the connection to
+// the server closes.
+var ErrClosed = errors.New("connection to the mining server closed")
+
+// Client is the worker side of a connection to a mining server.
+type Client struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan *Message
+	closed  bool
+
+	jobs chan Job
+}
+
+// Dial connects to the mining server at addr.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:    conn,
+		pending: make(map[int]chan *Message),
+		jobs:    make(chan Job, 1),
+	}
+	go c.readLoop()
+
+	return c, nil
+}
+
+// Jobs returns the jobs notified by the server. It is closed along with the
+// connection. Only the latest job is kept while the worker is busy, so a job
+// that is replaced before being received is never seen.
+func (c *Client) Jobs() <-chan Job {
+	return c.jobs
+}
+
+// Close closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Subscribe registers the worker, whose blocks pay to address.
+func (c *Client) Subscribe(worker, address string) (*SubscribeResult, error) {
+	var result SubscribeResult
+
+	err := c.call(MethodSubscribe, SubscribeParams{Worker: worker, Address: address}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Submit sends a share to the server.
+func (c *Client) Submit(params SubmitParams) (*SubmitResult, error) {
+	var result SubmitResult
+
+	err := c.call(MethodSubmit, params, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *Client) call(method string, params, result interface{}) error {
+	buffer, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	reply := make(chan *Message, 1)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = reply
+
+	msg, err := json.Marshal(Message{ID: &id, Method: method, Params: buffer})
+	if err == nil {
+		_, err = c.conn.Write(append(msg, '\n'))
+	}
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	response, ok := <-reply
+	if !ok {
+		return ErrClosed
+	}
+
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+
+	return json.Unmarshal(response.Result, result)
+}
+
+func (c *Client) readLoop() {
+	defer func() {
+		c.mu.Lock()
+		c.closed = true
+		for id, reply := range c.pending {
+			close(reply)
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+
+		close(c.jobs)
+	}()
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineLength)
+
+	for scanner.Scan() {
+		var msg Message
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			return
+		}
+
+		if msg.ID == nil {
+			if msg.Method == MethodNotify {
+				var job Job
+				if json.Unmarshal(msg.Params, &job) == nil {
+					c.replaceJob(job)
+				}
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		reply, ok := c.pending[*msg.ID]
+		delete(c.pending, *msg.ID)
+		c.mu.Unlock()
+
+		if ok {
+			reply <- &msg
+		}
+	}
+}
+
+// replaceJob queues job in place of the one the worker has not received yet,
+// so the read loop never waits on the worker and replies keep flowing while
+// it submits. The read loop is the only sender, so the send cannot block.
+func (c *Client) replaceJob(job Job) {
+	select {
+	case old := <-c.jobs:
+		// the jobs dropped by the replaced one are dropped all the same
+		job.Clean = job.Clean || old.Clean
+	default:
+	}
+
+	c.jobs <- job
+}
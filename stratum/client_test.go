@@ -0,0 +1,75 @@
+package stratum
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+func TestSubmitWhileJobsPile(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	const jobs = 50
+
+	// the server notifies more jobs than the client buffers before it
+	// answers the submit
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for i := 1; i <= jobs; i++ {
+			params, _ := json.Marshal(Job{JobID: strconv.Itoa(i), Clean: i == 1})
+			msg, _ := json.Marshal(Message{Method: MethodNotify, Params: params})
+			conn.Write(append(msg, '\n'))
+		}
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var request Message
+			if json.Unmarshal(scanner.Bytes(), &request) != nil {
+				return
+			}
+
+			result, _ := json.Marshal(SubmitResult{Accepted: true})
+			msg, _ := json.Marshal(Message{ID: request.ID, Result: result})
+			conn.Write(append(msg, '\n'))
+		}
+	}()
+
+	client, err := Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	submitted := make(chan error, 1)
+	go func() {
+		_, err := client.Submit(SubmitParams{JobID: "1"})
+		submitted <- err
+	}()
+
+	select {
+	case err := <-submitted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Submit is stuck behind the jobs nobody received")
+	}
+
+	job := <-client.Jobs()
+	if job.JobID != strconv.Itoa(jobs) || !job.Clean {
+		t.Errorf("received job %s with clean %v, want the latest job %d with clean true", job.JobID, job.Clean, jobs)
+	}
+}
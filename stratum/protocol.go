@@ -0,0 +1,67 @@
+package stratum
+
+import (
+	"github.com/goccy/go-json"
+)
+
+// Methods of the protocol. Every message is a JSON object on its own line.
+// Requests carry an ID that the response echoes, notifications have none.
+const (
+	// MethodSubscribe registers a worker and the address its blocks pay
+	// to. The server answers and then notifies the worker of its first job.
+	MethodSubscribe = "mining.subscribe"
+
+	// MethodNotify hands a job to a worker. It is sent by the server.
+	MethodNotify = "mining.notify"
+
+	// MethodSubmit sends a share found by a worker.
+	MethodSubmit = "mining.submit"
+)
+
+// Message is any message of the protocol.
+type Message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// SubscribeParams are the params of MethodSubscribe.
+type SubscribeParams struct {
+	Worker  string `json:"worker"`
+	Address string `json:"address"`
+}
+
+// SubscribeResult is the result of MethodSubscribe.
+type SubscribeResult struct {
+	SessionID string `json:"session_id"`
+}
+
+// Job is the work handed to a worker with MethodNotify. The worker searches
+// for a nonce, and may move the timestamp forward, so the hash of the header
+// is below the share target. Shares below the block target solve the block.
+type Job struct {
+	JobID       string `json:"job_id"`
+	Height      int    `json:"height"`
+	Header      string `json:"header"`
+	Target      string `json:"target"`
+	ShareTarget string `json:"share_target"`
+
+	// Clean tells the worker to drop its previous jobs, which can no
+	// longer be submitted.
+	Clean bool `json:"clean"`
+}
+
+// SubmitParams are the params of MethodSubmit.
+type SubmitParams struct {
+	JobID     string `json:"job_id"`
+	Nonce     uint32 `json:"nonce"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// SubmitResult is the result of MethodSubmit.
+type SubmitResult struct {
+	Accepted bool   `json:"accepted"`
+	Block    string `json:"block,omitempty"`
+}
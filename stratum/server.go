@@ -0,0 +1,441 @@
+package stratum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+	"github.com/dev-rodrigobaliza/go-blockchain/mining"
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+	"github.com/dev-rodrigobaliza/go-blockchain/wallet"
+	"github.com/goccy/go-json"
+)
+
+const (
+	// DefaultShareDifficulty is how many times easier than the block target
+	// a share is by default.
+	DefaultShareDifficulty = 256
+
+	// maxLineLength is the longest message accepted from a worker.
+	maxLineLength = 64 * 1024
+
+	// maxJobsPerWorker is how many of its latest jobs on the current tip a
+	// worker may still submit shares for.
+	maxJobsPerWorker = 8
+
+	// jobUpdateInterval is the shortest time between two updates of the
+	// jobs for new transactions.
+	jobUpdateInterval = 5 * time.Second
+
+	// writeTimeout is how long a worker has to take in a message before
+	// it is disconnected.
+	writeTimeout = 10 * time.Second
+)
+
+var (
+	errNotSubscribed = errors.New("worker is not subscribed")
+	errUnknownJob    = errors.New("unknown or stale job")
+	errDuplicate     = errors.New("duplicate share")
+	errLowDifficulty = errors.New("share above the share target")
+	errTimeTooOld    = errors.New("timestamp before the job timestamp")
+)
+
+// maxTarget is the easiest possible target, which shares never exceed.
+var maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// Config holds what the server needs from the node.
+type Config struct {
+	// Generator builds the block templates handed out as jobs.
+	Generator *mining.BlkTmplGenerator
+
+	// Params are the params of the chain mined, used to check the
+	// addresses of the workers.
+	Params *params.ChainParams
+
+	// ShareDifficulty is how many times easier than the block target a
+	// share is. Zero means DefaultShareDifficulty.
+	ShareDifficulty int64
+
+	// SubmitBlock hands a solved block to the node, which validates and
+	// relays it like any other block.
+	SubmitBlock func(block *blockchain.Block) error
+}
+
+// Server hands out mining jobs to workers connected over TCP and collects
+// their shares. Each worker gets its own block template paying to its
+// address, so workers never search the same space.
+type Server struct {
+	cfg Config
+
+	mu          sync.Mutex
+	workers     map[*worker]bool
+	nextJobID   uint64
+	nextSession uint64
+
+	// lastUpdate is when the jobs were last updated, and updatePending
+	// tells an update for new transactions is already waiting
+	lastUpdate    time.Time
+	updatePending bool
+}
+
+type worker struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	session string
+	name    string
+	address string
+	jobs    map[string]*job
+	shares  int
+}
+
+type job struct {
+	id          uint64
+	block       blockchain.Block
+	target      *big.Int
+	shareTarget *big.Int
+	submitted   map[string]bool
+}
+
+// NewServer returns a server with no workers.
+func NewServer(cfg *Config) *Server {
+	if cfg.ShareDifficulty <= 0 {
+		cfg.ShareDifficulty = DefaultShareDifficulty
+	}
+
+	return &Server{
+		cfg:     *cfg,
+		workers: make(map[*worker]bool),
+	}
+}
+
+// ListenAndServe listens on addr and serves the workers connecting to it.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ln)
+}
+
+// Serve serves the workers connecting to ln until it is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.handleConn(conn)
+	}
+}
+
+// UpdateJobs hands a new job to every worker, built from a fresh block
+// template. A clean update, on a new tip, invalidates the previous jobs.
+// Other updates, for new transactions, are sent at most once every
+// jobUpdateInterval: the ones coming sooner are merged in a single update at
+// the end of the interval.
+func (s *Server) UpdateJobs(clean bool) {
+	if !clean {
+		s.mu.Lock()
+		wait := time.Until(s.lastUpdate.Add(jobUpdateInterval))
+		if wait > 0 {
+			if !s.updatePending {
+				s.updatePending = true
+				time.AfterFunc(wait, func() {
+					s.mu.Lock()
+					s.updatePending = false
+					s.mu.Unlock()
+
+					s.updateJobs(false)
+				})
+			}
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+
+	s.updateJobs(clean)
+}
+
+// updateJobs sends the new jobs right away.
+func (s *Server) updateJobs(clean bool) {
+	s.mu.Lock()
+	s.lastUpdate = time.Now()
+	workers := make([]*worker, 0, len(s.workers))
+	for w := range s.workers {
+		if w.address != "" {
+			workers = append(workers, w)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, w := range workers {
+		s.sendJob(w, clean)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	w := &worker{conn: conn, jobs: make(map[string]*job)}
+
+	s.mu.Lock()
+	s.workers[w] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.workers, w)
+		s.mu.Unlock()
+
+		conn.Close()
+		if w.name != "" {
+			fmt.Printf("Worker %s disconnected after %d shares\n", w.name, w.shares)
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineLength)
+
+	for scanner.Scan() {
+		var msg Message
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			fmt.Printf("Invalid message from worker: %s\n", err)
+			return
+		}
+
+		switch msg.Method {
+		case MethodSubscribe:
+			s.handleSubscribe(w, &msg)
+
+		case MethodSubmit:
+			s.handleSubmit(w, &msg)
+
+		default:
+			w.reply(msg.ID, nil, fmt.Errorf("unknown method %q", msg.Method))
+		}
+	}
+}
+
+func (s *Server) handleSubscribe(w *worker, msg *Message) {
+	var p SubscribeParams
+	err := json.Unmarshal(msg.Params, &p)
+	if err != nil {
+		w.reply(msg.ID, nil, err)
+		return
+	}
+
+	if !wallet.ValidateAddress(p.Address, s.cfg.Params) {
+		w.reply(msg.ID, nil, fmt.Errorf("invalid address %q", p.Address))
+		return
+	}
+
+	s.mu.Lock()
+	s.nextSession++
+	w.session = strconv.FormatUint(s.nextSession, 16)
+	w.name = p.Worker
+	w.address = p.Address
+	s.mu.Unlock()
+
+	fmt.Printf("Worker %s subscribed, paying to %s\n", w.name, w.address)
+
+	w.reply(msg.ID, SubscribeResult{SessionID: w.session}, nil)
+	s.sendJob(w, true)
+}
+
+func (s *Server) handleSubmit(w *worker, msg *Message) {
+	var p SubmitParams
+	err := json.Unmarshal(msg.Params, &p)
+	if err != nil {
+		w.reply(msg.ID, nil, err)
+		return
+	}
+
+	block, err := s.checkShare(w, &p)
+	if err != nil {
+		w.reply(msg.ID, SubmitResult{Accepted: false}, err)
+		return
+	}
+
+	if block == nil {
+		w.reply(msg.ID, SubmitResult{Accepted: true}, nil)
+		return
+	}
+
+	fmt.Printf("Worker %s solved block %x\n", w.name, block.Hash)
+
+	err = s.cfg.SubmitBlock(block)
+	if err != nil {
+		w.reply(msg.ID, SubmitResult{Accepted: false}, err)
+		return
+	}
+
+	w.reply(msg.ID, SubmitResult{Accepted: true, Block: hex.EncodeToString(block.Hash)}, nil)
+}
+
+// checkShare validates a share and returns the solved block when it also
+// meets the block target.
+func (s *Server) checkShare(w *worker, p *SubmitParams) (*blockchain.Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w.address == "" {
+		return nil, errNotSubscribed
+	}
+
+	j, ok := w.jobs[p.JobID]
+	if !ok {
+		return nil, errUnknownJob
+	}
+
+	if p.Timestamp < j.block.Timestamp {
+		return nil, errTimeTooOld
+	}
+
+	key := fmt.Sprintf("%d:%d", p.Timestamp, p.Nonce)
+	if j.submitted[key] {
+		return nil, errDuplicate
+	}
+
+	header := j.block.BlockHeader
+	header.Nonce = p.Nonce
+	header.Timestamp = p.Timestamp
+	hash := header.BlockHash()
+
+	hashNum := new(big.Int).SetBytes(hash)
+	if hashNum.Cmp(j.shareTarget) >= 0 {
+		return nil, errLowDifficulty
+	}
+
+	j.submitted[key] = true
+	w.shares++
+
+	if hashNum.Cmp(j.target) >= 0 {
+		return nil, nil
+	}
+
+	block := j.block
+	block.BlockHeader = header
+	block.Hash = hash
+
+	return &block, nil
+}
+
+// sendJob builds a new template for the worker and notifies it.
+func (s *Server) sendJob(w *worker, clean bool) {
+	template, err := s.cfg.Generator.NewBlockTemplate(w.address)
+	if err != nil {
+		fmt.Printf("Failed to build a block template: %s\n", err)
+		return
+	}
+
+	target := blockchain.CompactToBig(template.Block.Bits)
+	shareTarget := new(big.Int).Mul(target, big.NewInt(s.cfg.ShareDifficulty))
+	if shareTarget.Cmp(maxTarget) > 0 {
+		shareTarget.Set(maxTarget)
+	}
+
+	s.mu.Lock()
+	s.nextJobID++
+	jobID := strconv.FormatUint(s.nextJobID, 16)
+	if clean {
+		w.jobs = make(map[string]*job)
+	}
+	w.pruneJobs(template.Block.PrevHash)
+	w.jobs[jobID] = &job{
+		id:          s.nextJobID,
+		block:       template.Block,
+		target:      target,
+		shareTarget: shareTarget,
+		submitted:   make(map[string]bool),
+	}
+	s.mu.Unlock()
+
+	w.notify(MethodNotify, Job{
+		JobID:       jobID,
+		Height:      template.Block.Height,
+		Header:      hex.EncodeToString(template.Block.BlockHeader.Serialize()),
+		Target:      fmt.Sprintf("%064x", target),
+		ShareTarget: fmt.Sprintf("%064x", shareTarget),
+		Clean:       clean,
+	})
+}
+
+// pruneJobs drops the jobs building on another block than prevHash, which
+// can no longer be mined, and the oldest jobs beyond maxJobsPerWorker - 1 to
+// make room for a new one. The caller holds the server lock.
+func (w *worker) pruneJobs(prevHash []byte) {
+	for id, j := range w.jobs {
+		if !bytes.Equal(j.block.PrevHash, prevHash) {
+			delete(w.jobs, id)
+		}
+	}
+
+	for len(w.jobs) >= maxJobsPerWorker {
+		var oldest string
+		for id, j := range w.jobs {
+			if oldest == "" || j.id < w.jobs[oldest].id {
+				oldest = id
+			}
+		}
+		delete(w.jobs, oldest)
+	}
+}
+
+func (w *worker) reply(id *int, result interface{}, err error) {
+	msg := Message{ID: id}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+
+	if result != nil {
+		buffer, merr := json.Marshal(result)
+		if merr != nil {
+			return
+		}
+		msg.Result = buffer
+	}
+
+	w.send(&msg)
+}
+
+func (w *worker) notify(method string, params interface{}) {
+	buffer, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+
+	w.send(&Message{Method: method, Params: buffer})
+}
+
+func (w *worker) send(msg *Message) {
+	buffer, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	// a worker that stops reading would otherwise block the server, and a
+	// partial write leaves the stream unusable, so it is disconnected
+	w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	_, err = w.conn.Write(append(buffer, '\n'))
+	if err != nil {
+		fmt.Printf("Failed to write to worker %s: %s\n", w.name, err)
+		w.conn.Close()
+	}
+}
@@ -0,0 +1,119 @@
+package stratum
+
+import (
+	"encoding/hex"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/dev-rodrigobaliza/go-blockchain/blockchain"
+	"github.com/dev-rodrigobaliza/go-blockchain/mempool"
+	"github.com/dev-rodrigobaliza/go-blockchain/mining"
+	"github.com/dev-rodrigobaliza/go-blockchain/params"
+	"github.com/dev-rodrigobaliza/go-blockchain/wallet"
+)
+
+// newTestServer serves workers on a regtest chain created in a temporary
+// directory and returns its address along with the blocks it is handed.
+func newTestServer(t *testing.T) (string, <-chan *blockchain.Block) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+
+	owner := wallet.NewWallet()
+	chain := blockchain.InitBlockChain(string(owner.Address(&params.RegTest)), "test", &params.RegTest)
+	t.Cleanup(func() {
+		chain.Database.Close()
+	})
+
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	UTXOSet.Reindex()
+
+	pool := mempool.New(&mempool.Config{Chain: chain})
+	blocks := make(chan *blockchain.Block, 1)
+	server := NewServer(&Config{
+		Generator: mining.NewBlkTmplGenerator(&mining.Policy{}, chain, pool),
+		Params:    &params.RegTest,
+		SubmitBlock: func(block *blockchain.Block) error {
+			blocks <- block
+			return nil
+		},
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ln.Close()
+	})
+	go server.Serve(ln)
+
+	return ln.Addr().String(), blocks
+}
+
+func TestSubmitSolvedBlock(t *testing.T) {
+	addr, blocks := newTestServer(t)
+
+	client, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	_, err = client.Subscribe("test", "not an address")
+	if err == nil {
+		t.Fatal("Subscribe accepted an invalid address")
+	}
+
+	_, err = client.Subscribe("test", string(wallet.NewWallet().Address(&params.RegTest)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := <-client.Jobs()
+
+	buffer, err := hex.DecodeString(job.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header blockchain.BlockHeader
+	err = header.Deserialize(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, _ := new(big.Int).SetString(job.Target, 16)
+	for new(big.Int).SetBytes(header.BlockHash()).Cmp(target) >= 0 {
+		header.Nonce++
+	}
+
+	share := SubmitParams{JobID: job.JobID, Nonce: header.Nonce, Timestamp: header.Timestamp}
+	result, err := client.Submit(share)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Accepted || result.Block != hex.EncodeToString(header.BlockHash()) {
+		t.Fatalf("Submit returned %+v, want the block %x", result, header.BlockHash())
+	}
+
+	block := <-blocks
+	if block.Nonce != header.Nonce || block.Height != job.Height {
+		t.Errorf("server submitted block %x at height %d, want the solved one", block.Hash, block.Height)
+	}
+
+	_, err = client.Submit(share)
+	if err == nil {
+		t.Error("Submit accepted the same share twice")
+	}
+}
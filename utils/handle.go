@@ -1,9 +1,9 @@
-package utils
-
-import "log"
-
-func Handle(err error) {
-	if err != nil {
-		log.Panic(err)
-	}
-}
+package utils
+
+import "log"
+
+func Handle(err error) {
+	if err != nil {
+		log.Panic(err)
+	}
+}